@@ -4,24 +4,21 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 )
 
 // CacheLocator retrieves the location of the Postgres binary cache returning it to location.
 // The result of whether this cache is present will be returned to exists.
 type CacheLocator func() (location string, exists bool)
 
+const cachedArchivePrefix = "embedded-postgres-binaries-"
+
 func defaultCacheLocator(cacheDirectory string, versionStrategy VersionStrategy) CacheLocator {
 	return func() (string, bool) {
-		if cacheDirectory == "" {
-			cacheDirectory = ".embedded-postgres-go"
-			if userHome, err := os.UserHomeDir(); err == nil {
-				cacheDirectory = filepath.Join(userHome, ".embedded-postgres-go")
-			}
-		}
-
 		operatingSystem, architecture, version := versionStrategy()
-		cacheLocation := filepath.Join(cacheDirectory,
-			fmt.Sprintf("embedded-postgres-binaries-%s-%s-%s.txz",
+		cacheLocation := filepath.Join(resolveCacheDirectory(cacheDirectory),
+			fmt.Sprintf(cachedArchivePrefix+"%s-%s-%s.txz",
 				operatingSystem,
 				architecture,
 				version))
@@ -35,3 +32,70 @@ func defaultCacheLocator(cacheDirectory string, versionStrategy VersionStrategy)
 		return cacheLocation, !info.IsDir()
 	}
 }
+
+// resolveCacheDirectory returns cacheDirectory, or the default cache directory in the user's home when it is empty.
+func resolveCacheDirectory(cacheDirectory string) string {
+	if cacheDirectory != "" {
+		return cacheDirectory
+	}
+
+	if userHome, err := os.UserHomeDir(); err == nil {
+		return filepath.Join(userHome, ".embedded-postgres-go")
+	}
+
+	return ".embedded-postgres-go"
+}
+
+// PurgeCache removes every cached binaries archive from the configured CachePath, or the default cache directory.
+// Extracted binaries, including those in the default RuntimePath within the cache directory, are left untouched.
+// It does nothing if the cache directory does not exist.
+func PurgeCache(config Config) error {
+	return purgeCache(config, func(os.FileInfo) bool { return true })
+}
+
+// PurgeCacheOlderThan behaves as PurgeCache but only removes archives which were downloaded more than age ago.
+func PurgeCacheOlderThan(config Config, age time.Duration) error {
+	cutoff := time.Now().Add(-age)
+
+	return purgeCache(config, func(info os.FileInfo) bool {
+		return info.ModTime().Before(cutoff)
+	})
+}
+
+func purgeCache(config Config, shouldRemove func(os.FileInfo) bool) error {
+	cacheDirectory := resolveCacheDirectory(config.cachePath)
+
+	entries, err := os.ReadDir(cacheDirectory)
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	if err != nil {
+		return fmt.Errorf("unable to purge cache %s: %w", cacheDirectory, err)
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasPrefix(entry.Name(), cachedArchivePrefix) || strings.HasSuffix(entry.Name(), ".lock") {
+			continue
+		}
+
+		info, err := entry.Info()
+		if os.IsNotExist(err) {
+			continue
+		}
+
+		if err != nil {
+			return fmt.Errorf("unable to purge cache %s: %w", cacheDirectory, err)
+		}
+
+		if !shouldRemove(info) {
+			continue
+		}
+
+		if err := os.Remove(filepath.Join(cacheDirectory, entry.Name())); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("unable to purge cache %s: %w", cacheDirectory, err)
+		}
+	}
+
+	return nil
+}
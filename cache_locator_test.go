@@ -1,9 +1,13 @@
 package embeddedpostgres
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func Test_defaultCacheLocator_NotExists(t *testing.T) {
@@ -27,3 +31,42 @@ func Test_defaultCacheLocator_CustomPath(t *testing.T) {
 	assert.Equal(t, cacheLocation, "/custom/path/embedded-postgres-binaries-a-b-1.2.3.txz")
 	assert.False(t, exists)
 }
+
+func Test_PurgeCache(t *testing.T) {
+	cacheDir, err := os.MkdirTemp("", "purge_cache_test")
+	require.NoError(t, err)
+
+	defer func() {
+		if err := os.RemoveAll(cacheDir); err != nil {
+			panic(err)
+		}
+	}()
+
+	oldArchive := filepath.Join(cacheDir, "embedded-postgres-binaries-linux-amd64-15.8.0.txz")
+	newArchive := filepath.Join(cacheDir, "embedded-postgres-binaries-linux-amd64-16.4.0.txz")
+	otherFile := filepath.Join(cacheDir, "notes.txt")
+	extracted := filepath.Join(cacheDir, "extracted", "bin")
+
+	require.NoError(t, os.MkdirAll(extracted, 0755))
+	for _, file := range []string{oldArchive, newArchive, otherFile} {
+		require.NoError(t, os.WriteFile(file, []byte("content"), 0600))
+	}
+
+	twoDaysAgo := time.Now().Add(-48 * time.Hour)
+	require.NoError(t, os.Chtimes(oldArchive, twoDaysAgo, twoDaysAgo))
+
+	config := DefaultConfig().CachePath(cacheDir)
+
+	require.NoError(t, PurgeCacheOlderThan(config, 24*time.Hour))
+	assert.NoFileExists(t, oldArchive)
+	assert.FileExists(t, newArchive)
+
+	require.NoError(t, PurgeCache(config))
+	assert.NoFileExists(t, newArchive)
+	assert.FileExists(t, otherFile)
+	assert.DirExists(t, extracted)
+}
+
+func Test_PurgeCache_CacheNotExists(t *testing.T) {
+	assert.NoError(t, PurgeCache(DefaultConfig().CachePath("/does-not-exist")))
+}
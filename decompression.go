@@ -2,16 +2,75 @@ package embeddedpostgres
 
 import (
 	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
 	"fmt"
 	"io"
 	"os"
+	"path"
 	"path/filepath"
+	"strings"
 
+	"github.com/klauspost/compress/zstd"
 	"github.com/xi2/xz"
 )
 
-func defaultTarReader(xzReader *xz.Reader) (func() (*tar.Header, error), func() io.Reader) {
-	tarReader := tar.NewReader(xzReader)
+// xzMagic, gzipMagic and zstdMagic are the leading bytes identifying the supported archive compressions.
+//
+//nolint:gochecknoglobals
+var (
+	xzMagic   = []byte{0xFD, '7', 'z', 'X', 'Z', 0x00}
+	gzipMagic = []byte{0x1F, 0x8B}
+	zstdMagic = []byte{0x28, 0xB5, 0x2F, 0xFD}
+)
+
+// Extractor extracts the binaries archive at archivePath, as located by the CacheLocator, into extractPath so that
+// extractPath/bin contains pg_ctl, initdb and postgres. Extraction should be abandoned when ctx is cancelled.
+type Extractor interface {
+	Extract(ctx context.Context, archivePath, extractPath string) error
+}
+
+// ExtractorFunc adapts a function to an Extractor.
+type ExtractorFunc func(ctx context.Context, archivePath, extractPath string) error
+
+// Extract calls f(ctx, archivePath, extractPath).
+func (f ExtractorFunc) Extract(ctx context.Context, archivePath, extractPath string) error {
+	return f(ctx, archivePath, extractPath)
+}
+
+// tarExtractor is the default Extractor, extracting tar archives compressed with xz, gzip or zstd.
+type tarExtractor struct {
+	tarReader func(io.Reader) (func() (*tar.Header, error), func() io.Reader)
+}
+
+func defaultExtractor(config Config) Extractor {
+	return tarExtractor{tarReader: configuredTarReader(config)}
+}
+
+// configuredTarReader returns the tar reader extracting the archive entries config needs, all of them unless
+// MinimalExtract is set.
+func configuredTarReader(config Config) func(io.Reader) (func() (*tar.Header, error), func() io.Reader) {
+	tarReader := defaultTarReader
+
+	if config.archivePathPrefix != "" {
+		tarReader = prefixTarReader(tarReader, config.archivePathPrefix)
+	}
+
+	if config.minimalExtract {
+		return minimalTarReader(tarReader, config.extensions)
+	}
+
+	return tarReader
+}
+
+func (e tarExtractor) Extract(ctx context.Context, archivePath, extractPath string) error {
+	return decompressTar(ctx, e.tarReader, archivePath, extractPath)
+}
+
+func defaultTarReader(decompressedReader io.Reader) (func() (*tar.Header, error), func() io.Reader) {
+	tarReader := tar.NewReader(decompressedReader)
 
 	return func() (*tar.Header, error) {
 			return tarReader.Next()
@@ -20,36 +79,159 @@ func defaultTarReader(xzReader *xz.Reader) (func() (*tar.Header, error), func()
 		}
 }
 
-func decompressTarXz(tarReader func(*xz.Reader) (func() (*tar.Header, error), func() io.Reader), path, extractPath string) error {
-	tempExtractPath, err := os.MkdirTemp(filepath.Dir(extractPath), "temp_")
+// minimalTarReader wraps tarReader so that archive entries which are not needed at runtime are skipped, keeping only
+// the scripts of the given extensions and of plpgsql, which initdb installs.
+func minimalTarReader(tarReader func(io.Reader) (func() (*tar.Header, error), func() io.Reader), extensions []string) func(io.Reader) (func() (*tar.Header, error), func() io.Reader) {
+	return func(decompressedReader io.Reader) (func() (*tar.Header, error), func() io.Reader) {
+		readNext, reader := tarReader(decompressedReader)
+
+		return func() (*tar.Header, error) {
+			for {
+				header, err := readNext()
+				if err != nil || isRuntimeEntry(header.Name, extensions) {
+					return header, err
+				}
+			}
+		}, reader
+	}
+}
+
+// prefixTarReader wraps tarReader so that only the archive entries under the directory prefix are read, with prefix
+// stripped from their names. Reading an archive without any such entries fails rather than extracting nothing.
+func prefixTarReader(tarReader func(io.Reader) (func() (*tar.Header, error), func() io.Reader), prefix string) func(io.Reader) (func() (*tar.Header, error), func() io.Reader) {
+	prefix = path.Clean(strings.ReplaceAll(prefix, `\`, "/")) + "/"
+
+	return func(decompressedReader io.Reader) (func() (*tar.Header, error), func() io.Reader) {
+		readNext, reader := tarReader(decompressedReader)
+		matched := false
+
+		return func() (*tar.Header, error) {
+			for {
+				header, err := readNext()
+				if err == io.EOF && !matched {
+					return nil, fmt.Errorf("archive has no entries under path prefix %s", prefix)
+				}
+
+				if err != nil {
+					return header, err
+				}
+
+				name := strings.TrimPrefix(header.Name, "./")
+				if !strings.HasPrefix(name, prefix) || name == prefix {
+					continue
+				}
+
+				matched = true
+				header.Name = strings.TrimPrefix(name, prefix)
+
+				return header, nil
+			}
+		}, reader
+	}
+}
+
+// isRuntimeEntry reports whether the archive entry name may be loaded by initdb or Postgres when only the given
+// extensions are created.
+func isRuntimeEntry(name string, extensions []string) bool {
+	segments := strings.Split(path.Clean(strings.TrimPrefix(name, "./")), "/")
+
+	switch segments[0] {
+	case "include":
+		return false
+	case "lib":
+		for _, segment := range segments[1:] {
+			if segment == "pgxs" || segment == "pkgconfig" {
+				return false
+			}
+		}
+
+		return path.Ext(name) != ".a"
+	case "share":
+		for i, segment := range segments[1:] {
+			switch segment {
+			case "doc", "man", "locale":
+				return false
+			case "extension":
+				if i+2 != len(segments)-1 {
+					return true
+				}
+
+				return isExtensionEntry(segments[len(segments)-1], extensions)
+			}
+		}
+	}
+
+	return true
+}
+
+// isExtensionEntry reports whether the control or script file named fileName belongs to plpgsql or one of extensions.
+func isExtensionEntry(fileName string, extensions []string) bool {
+	if !strings.HasSuffix(fileName, ".control") && !strings.HasSuffix(fileName, ".sql") {
+		return true
+	}
+
+	extension := strings.TrimSuffix(strings.TrimSuffix(fileName, ".control"), ".sql")
+	if i := strings.Index(extension, "--"); i >= 0 {
+		extension = extension[:i]
+	}
+
+	if extension == "plpgsql" {
+		return true
+	}
+
+	for _, name := range extensions {
+		if name == extension {
+			return true
+		}
+	}
+
+	return false
+}
+
+// decompressTar extracts the tar archive at path into extractPath. The archive may be compressed with xz, gzip or
+// zstd, the format being detected from the first bytes of the file.
+func decompressTar(ctx context.Context, tarReader func(io.Reader) (func() (*tar.Header, error), func() io.Reader), path, extractPath string) error {
+	tarFile, err := os.Open(path)
 	if err != nil {
 		return errorUnableToExtract(path, extractPath, err)
 	}
+
 	defer func() {
-		if err := os.RemoveAll(tempExtractPath); err != nil {
+		if err := tarFile.Close(); err != nil {
 			panic(err)
 		}
 	}()
 
-	tarFile, err := os.Open(path)
+	return extractTar(ctx, tarReader, tarFile, path, extractPath)
+}
+
+// extractTar extracts the compressed tar archive read from archive into extractPath as it is read, source naming the
+// archive in errors.
+func extractTar(ctx context.Context, tarReader func(io.Reader) (func() (*tar.Header, error), func() io.Reader), archive io.Reader, source, extractPath string) error {
+	tempExtractPath, err := os.MkdirTemp(filepath.Dir(extractPath), "temp_")
 	if err != nil {
-		return errorUnableToExtract(path, extractPath, err)
+		return errorUnableToExtract(source, extractPath, err)
 	}
-
 	defer func() {
-		if err := tarFile.Close(); err != nil {
+		if err := os.RemoveAll(tempExtractPath); err != nil {
 			panic(err)
 		}
 	}()
 
-	xzReader, err := xz.NewReader(tarFile, 0)
+	decompressedReader, closeDecompressor, err := newDecompressingReader(archive)
 	if err != nil {
-		return errorUnableToExtract(path, extractPath, err)
+		return errorUnableToExtract(source, extractPath, err)
 	}
 
-	readNext, reader := tarReader(xzReader)
+	defer closeDecompressor()
+
+	readNext, reader := tarReader(decompressedReader)
 
 	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
 		header, err := readNext()
 
 		if err == io.EOF {
@@ -109,6 +291,43 @@ func decompressTarXz(tarReader func(*xz.Reader) (func() (*tar.Header, error), fu
 	return nil
 }
 
+// newDecompressingReader returns a reader which decompresses archive according to its magic bytes, along with a
+// function releasing any resources held by the decompressor.
+func newDecompressingReader(archive io.Reader) (io.Reader, func(), error) {
+	bufferedReader := bufio.NewReader(archive)
+
+	header, err := bufferedReader.Peek(len(xzMagic))
+	if err != nil && err != io.EOF {
+		return nil, nil, err
+	}
+
+	switch {
+	case bytes.HasPrefix(header, xzMagic):
+		xzReader, err := xz.NewReader(bufferedReader, 0)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return xzReader, func() {}, nil
+	case bytes.HasPrefix(header, gzipMagic):
+		gzipReader, err := gzip.NewReader(bufferedReader)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return gzipReader, func() { _ = gzipReader.Close() }, nil
+	case bytes.HasPrefix(header, zstdMagic):
+		zstdReader, err := zstd.NewReader(bufferedReader)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return zstdReader, zstdReader.Close, nil
+	default:
+		return nil, nil, fmt.Errorf("unsupported archive format, expected a tar archive compressed with xz, gzip or zstd")
+	}
+}
+
 func errorUnableToExtract(cacheLocation, binariesPath string, err error) error {
 	return fmt.Errorf("unable to extract postgres archive %s to %s, if running parallel tests, configure RuntimePath to isolate testing directories, %w",
 		cacheLocation,
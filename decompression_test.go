@@ -2,6 +2,9 @@ package embeddedpostgres
 
 import (
 	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -13,10 +16,9 @@ import (
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
-	"github.com/xi2/xz"
 )
 
-func Test_decompressTarXz(t *testing.T) {
+func Test_decompressTar(t *testing.T) {
 	tempDir, err := os.MkdirTemp("", "temp_tar_test")
 	if err != nil {
 		panic(err)
@@ -28,7 +30,7 @@ func Test_decompressTarXz(t *testing.T) {
 	archive, cleanUp := createTempXzArchive()
 	defer cleanUp()
 
-	err = decompressTarXz(defaultTarReader, archive, tempDir)
+	err = decompressTar(context.Background(), defaultTarReader, archive, tempDir)
 
 	assert.NoError(t, err)
 
@@ -41,8 +43,66 @@ func Test_decompressTarXz(t *testing.T) {
 	assert.Equal(t, "b33r is g00d", string(fileContentBytes))
 }
 
-func Test_decompressTarXz_ErrorWhenFileNotExists(t *testing.T) {
-	err := decompressTarXz(defaultTarReader, "/does-not-exist", "/also-fake")
+func Test_decompressTar_GzipAndZstd(t *testing.T) {
+	for name, createArchive := range map[string]func() (string, func()){
+		"gzip": createTempGzipArchive,
+		"zstd": createTempZstdArchive,
+	} {
+		t.Run(name, func(t *testing.T) {
+			tempDir, err := os.MkdirTemp("", "temp_tar_test")
+			require.NoError(t, err)
+
+			defer func() {
+				if err := os.RemoveAll(tempDir); err != nil {
+					panic(err)
+				}
+			}()
+
+			archive, cleanUp := createArchive()
+			defer cleanUp()
+
+			err = decompressTar(context.Background(), defaultTarReader, archive, tempDir)
+			require.NoError(t, err)
+
+			fileContentBytes, err := os.ReadFile(filepath.Join(tempDir, "dir1", "dir2", "some_content"))
+			assert.NoError(t, err)
+			assert.Equal(t, "b33r is g00d", string(fileContentBytes))
+		})
+	}
+}
+
+func Test_decompressTar_ErrorWhenFormatUnsupported(t *testing.T) {
+	archive, cleanUp := writeFileWithBase64Content("remote_fetch_test*.tar", "bm90IGFuIGFyY2hpdmU=")
+	defer cleanUp()
+
+	err := decompressTar(context.Background(), defaultTarReader, archive, filepath.Join(os.TempDir(), "unsupported_extract"))
+
+	assert.ErrorContains(t, err, "unsupported archive format, expected a tar archive compressed with xz, gzip or zstd")
+}
+
+func Test_decompressTar_ErrorWhenContextCancelled(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "temp_tar_test")
+	if err != nil {
+		panic(err)
+	}
+	if err := syscall.Rmdir(tempDir); err != nil {
+		panic(err)
+	}
+
+	archive, cleanUp := createTempXzArchive()
+	defer cleanUp()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err = decompressTar(ctx, defaultTarReader, archive, tempDir)
+
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.NoFileExists(t, filepath.Join(tempDir, "dir1", "dir2", "some_content"))
+}
+
+func Test_decompressTar_ErrorWhenFileNotExists(t *testing.T) {
+	err := decompressTar(context.Background(), defaultTarReader, "/does-not-exist", "/also-fake")
 
 	assert.Error(t, err)
 	assert.Contains(
@@ -52,7 +112,7 @@ func Test_decompressTarXz_ErrorWhenFileNotExists(t *testing.T) {
 	)
 }
 
-func Test_decompressTarXz_ErrorWhenErrorDuringRead(t *testing.T) {
+func Test_decompressTar_ErrorWhenErrorDuringRead(t *testing.T) {
 	tempDir, err := os.MkdirTemp("", "temp_tar_test")
 	if err != nil {
 		panic(err)
@@ -64,7 +124,7 @@ func Test_decompressTarXz_ErrorWhenErrorDuringRead(t *testing.T) {
 	archive, cleanUp := createTempXzArchive()
 	defer cleanUp()
 
-	err = decompressTarXz(func(reader *xz.Reader) (func() (*tar.Header, error), func() io.Reader) {
+	err = decompressTar(context.Background(), func(reader io.Reader) (func() (*tar.Header, error), func() io.Reader) {
 		return func() (*tar.Header, error) {
 			return nil, errors.New("oh noes")
 		}, nil
@@ -73,7 +133,7 @@ func Test_decompressTarXz_ErrorWhenErrorDuringRead(t *testing.T) {
 	assert.EqualError(t, err, "unable to extract postgres archive: oh noes")
 }
 
-func Test_decompressTarXz_ErrorWhenFailedToReadFileToCopy(t *testing.T) {
+func Test_decompressTar_ErrorWhenFailedToReadFileToCopy(t *testing.T) {
 	tempDir, err := os.MkdirTemp("", "temp_tar_test")
 	if err != nil {
 		panic(err)
@@ -88,7 +148,7 @@ func Test_decompressTarXz_ErrorWhenFailedToReadFileToCopy(t *testing.T) {
 		panic(err)
 	}
 
-	fileBlockingExtractTarReader := func(reader *xz.Reader) (func() (*tar.Header, error), func() io.Reader) {
+	fileBlockingExtractTarReader := func(reader io.Reader) (func() (*tar.Header, error), func() io.Reader) {
 		shouldReadFile := true
 
 		return func() (*tar.Header, error) {
@@ -108,12 +168,12 @@ func Test_decompressTarXz_ErrorWhenFailedToReadFileToCopy(t *testing.T) {
 			}
 	}
 
-	err = decompressTarXz(fileBlockingExtractTarReader, archive, tempDir)
+	err = decompressTar(context.Background(), fileBlockingExtractTarReader, archive, tempDir)
 
 	assert.Regexp(t, "^unable to extract postgres archive:.+$", err)
 }
 
-func Test_decompressTarXz_ErrorWhenFileToCopyToNotExists(t *testing.T) {
+func Test_decompressTar_ErrorWhenFileToCopyToNotExists(t *testing.T) {
 	tempDir, err := os.MkdirTemp("", "temp_tar_test")
 	if err != nil {
 		panic(err)
@@ -125,7 +185,7 @@ func Test_decompressTarXz_ErrorWhenFileToCopyToNotExists(t *testing.T) {
 	archive, cleanUp := createTempXzArchive()
 	defer cleanUp()
 
-	fileBlockingExtractTarReader := func(reader *xz.Reader) (func() (*tar.Header, error), func() io.Reader) {
+	fileBlockingExtractTarReader := func(reader io.Reader) (func() (*tar.Header, error), func() io.Reader) {
 		shouldReadFile := true
 
 		return func() (*tar.Header, error) {
@@ -145,12 +205,12 @@ func Test_decompressTarXz_ErrorWhenFileToCopyToNotExists(t *testing.T) {
 			}
 	}
 
-	err = decompressTarXz(fileBlockingExtractTarReader, archive, tempDir)
+	err = decompressTar(context.Background(), fileBlockingExtractTarReader, archive, tempDir)
 
 	assert.Regexp(t, "^unable to extract postgres archive:.+$", err)
 }
 
-func Test_decompressTarXz_ErrorWhenArchiveCorrupted(t *testing.T) {
+func Test_decompressTar_ErrorWhenArchiveCorrupted(t *testing.T) {
 	tempDir, err := os.MkdirTemp("", "temp_tar_test")
 	if err != nil {
 		panic(err)
@@ -180,12 +240,12 @@ func Test_decompressTarXz_ErrorWhenArchiveCorrupted(t *testing.T) {
 		panic(err)
 	}
 
-	err = decompressTarXz(defaultTarReader, archive, tempDir)
+	err = decompressTar(context.Background(), defaultTarReader, archive, tempDir)
 
 	assert.EqualError(t, err, "unable to extract postgres archive: xz: data is corrupt")
 }
 
-func Test_decompressTarXz_ErrorWithInvalidDestination(t *testing.T) {
+func Test_decompressTar_ErrorWithInvalidDestination(t *testing.T) {
 	archive, cleanUp := createTempXzArchive()
 	defer cleanUp()
 
@@ -197,10 +257,156 @@ func Test_decompressTarXz_ErrorWithInvalidDestination(t *testing.T) {
 
 	op := fmt.Sprintf(path.Join(tempDir, "%c"), rune(0))
 
-	err = decompressTarXz(defaultTarReader, archive, op)
+	err = decompressTar(context.Background(), defaultTarReader, archive, op)
 	assert.EqualError(
 		t,
 		err,
 		fmt.Sprintf("unable to extract postgres archive: mkdir %s: invalid argument", op),
 	)
 }
+
+func Test_minimalTarReader(t *testing.T) {
+	var archive bytes.Buffer
+	tarWriter := tar.NewWriter(&archive)
+
+	names := []string{
+		"bin/postgres",
+		"include/postgresql/server/postgres.h",
+		"lib/libpq.so.5",
+		"lib/libpq.a",
+		"lib/pkgconfig/libpq.pc",
+		"lib/postgresql/hstore.so",
+		"lib/postgresql/pgxs/src/Makefile.global",
+		"share/doc/postgresql/README",
+		"share/locale/de/LC_MESSAGES/postgres-16.mo",
+		"share/man/man1/psql.1",
+		"share/postgresql/postgres.bki",
+		"share/postgresql/timezone/UTC",
+		"share/postgresql/extension/plpgsql.control",
+		"share/postgresql/extension/plpgsql--1.0.sql",
+		"share/postgresql/extension/hstore.control",
+		"share/postgresql/extension/hstore--1.4--1.5.sql",
+		"share/postgresql/extension/cube.control",
+		"share/postgresql/extension/cube--1.4.sql",
+	}
+
+	for _, name := range names {
+		require.NoError(t, tarWriter.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0644, Size: 1}))
+		_, err := tarWriter.Write([]byte("x"))
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, tarWriter.Close())
+
+	readNext, _ := minimalTarReader(defaultTarReader, []string{"hstore"})(&archive)
+
+	var extracted []string
+	for {
+		header, err := readNext()
+		if err == io.EOF {
+			break
+		}
+
+		require.NoError(t, err)
+		extracted = append(extracted, header.Name)
+	}
+
+	assert.Equal(t, []string{
+		"bin/postgres",
+		"lib/libpq.so.5",
+		"lib/postgresql/hstore.so",
+		"share/postgresql/postgres.bki",
+		"share/postgresql/timezone/UTC",
+		"share/postgresql/extension/plpgsql.control",
+		"share/postgresql/extension/plpgsql--1.0.sql",
+		"share/postgresql/extension/hstore.control",
+		"share/postgresql/extension/hstore--1.4--1.5.sql",
+	}, extracted)
+}
+
+func Test_defaultExtractor(t *testing.T) {
+	archive, cleanUp := createTempXzArchive()
+	defer cleanUp()
+
+	extractPath := filepath.Join(t.TempDir(), "extracted")
+
+	err := defaultExtractor(DefaultConfig()).Extract(context.Background(), archive, extractPath)
+
+	assert.NoError(t, err)
+	assert.FileExists(t, filepath.Join(extractPath, "dir1", "dir2", "some_content"))
+}
+
+// writeMultiPlatformArchive writes a gzipped tar archive bundling the binaries of two platforms in directories of
+// their own, as some mirrors publish them.
+func writeMultiPlatformArchive(t *testing.T) string {
+	archivePath := filepath.Join(t.TempDir(), "embedded-postgres-binaries-multi-16.4.0.tgz")
+
+	archiveFile, err := os.Create(archivePath)
+	require.NoError(t, err)
+
+	gzipWriter := gzip.NewWriter(archiveFile)
+	tarWriter := tar.NewWriter(gzipWriter)
+
+	for _, platform := range []string{"darwin-arm64", "linux-amd64"} {
+		require.NoError(t, tarWriter.WriteHeader(&tar.Header{Name: "./" + platform + "/", Typeflag: tar.TypeDir, Mode: 0755}))
+
+		for _, name := range []string{"bin/pg_ctl", "share/postgresql/postgres.bki"} {
+			content := platform + " " + name
+			require.NoError(t, tarWriter.WriteHeader(&tar.Header{Name: "./" + platform + "/" + name, Typeflag: tar.TypeReg, Mode: 0755, Size: int64(len(content))}))
+			_, err := tarWriter.Write([]byte(content))
+			require.NoError(t, err)
+		}
+	}
+
+	require.NoError(t, tarWriter.WriteHeader(&tar.Header{Name: "README", Typeflag: tar.TypeReg, Mode: 0644, Size: 1}))
+	_, err = tarWriter.Write([]byte("x"))
+	require.NoError(t, err)
+
+	require.NoError(t, tarWriter.Close())
+	require.NoError(t, gzipWriter.Close())
+	require.NoError(t, archiveFile.Close())
+
+	return archivePath
+}
+
+func Test_defaultExtractor_ArchivePathPrefix(t *testing.T) {
+	archivePath := writeMultiPlatformArchive(t)
+	extractPath := filepath.Join(t.TempDir(), "extracted")
+
+	err := defaultExtractor(DefaultConfig().ArchivePathPrefix("linux-amd64/")).Extract(context.Background(), archivePath, extractPath)
+	require.NoError(t, err)
+
+	content, err := os.ReadFile(filepath.Join(extractPath, "bin", "pg_ctl"))
+	require.NoError(t, err)
+	assert.Equal(t, "linux-amd64 bin/pg_ctl", string(content))
+
+	content, err = os.ReadFile(filepath.Join(extractPath, "share", "postgresql", "postgres.bki"))
+	require.NoError(t, err)
+	assert.Equal(t, "linux-amd64 share/postgresql/postgres.bki", string(content))
+
+	assert.NoDirExists(t, filepath.Join(extractPath, "linux-amd64"))
+	assert.NoDirExists(t, filepath.Join(extractPath, "darwin-arm64"))
+	assert.NoFileExists(t, filepath.Join(extractPath, "README"))
+}
+
+func Test_defaultExtractor_ArchivePathPrefixWithMinimalExtract(t *testing.T) {
+	archivePath := writeMultiPlatformArchive(t)
+	extractPath := filepath.Join(t.TempDir(), "extracted")
+
+	err := defaultExtractor(DefaultConfig().ArchivePathPrefix("darwin-arm64").MinimalExtract(true)).Extract(context.Background(), archivePath, extractPath)
+	require.NoError(t, err)
+
+	content, err := os.ReadFile(filepath.Join(extractPath, "bin", "pg_ctl"))
+	require.NoError(t, err)
+	assert.Equal(t, "darwin-arm64 bin/pg_ctl", string(content))
+}
+
+func Test_defaultExtractor_ErrorWhenArchivePathPrefixMissing(t *testing.T) {
+	archivePath := writeMultiPlatformArchive(t)
+	extractPath := filepath.Join(t.TempDir(), "extracted")
+
+	err := defaultExtractor(DefaultConfig().ArchivePathPrefix("windows-amd64")).Extract(context.Background(), archivePath, extractPath)
+
+	assert.ErrorContains(t, err, "archive has no entries under path prefix windows-amd64/")
+	assert.NoDirExists(t, filepath.Join(extractPath, "bin"))
+}
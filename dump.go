@@ -0,0 +1,163 @@
+package embeddedpostgres
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// DumpFormat is the output format of pg_dump.
+type DumpFormat string
+
+const (
+	// DumpFormatPlain writes a plain SQL script.
+	DumpFormatPlain DumpFormat = "plain"
+	// DumpFormatCustom writes a compressed archive suitable for pg_restore, equivalent to pg_dump -Fc.
+	DumpFormatCustom DumpFormat = "custom"
+)
+
+// DumpOptions configures Dump.
+type DumpOptions struct {
+	// Format of the dump, defaults to DumpFormatPlain.
+	Format DumpFormat
+}
+
+// Dump writes the contents of databaseName to outputPath using the bundled pg_dump.
+func (ep *EmbeddedPostgres) Dump(databaseName, outputPath string, opts DumpOptions) error {
+	if !ep.started {
+		return ErrServerNotStarted
+	}
+
+	if _, err := runClientTool(ep.config, "pg_dump", dumpArgs(ep.config, databaseName, outputPath, opts)...); err != nil {
+		return fmt.Errorf("unable to dump database %s: %w", databaseName, err)
+	}
+
+	return nil
+}
+
+// RestoreOptions configures Restore.
+type RestoreOptions struct {
+	// Database to restore into, defaults to the configured database.
+	Database string
+	// RecreateDatabase drops and creates the database before restoring into it, as the superuser and owned by the
+	// configured user like RecreateDatabase. The maintenance database cannot be recreated.
+	RecreateDatabase bool
+}
+
+// Restore loads a dump produced by Dump or pg_dump into the running server. Custom format dumps are restored with
+// the bundled pg_restore, plain SQL dumps with psql.
+func (ep *EmbeddedPostgres) Restore(inputPath string, opts RestoreOptions) error {
+	if !ep.started {
+		return ErrServerNotStarted
+	}
+
+	database := opts.Database
+	if database == "" {
+		database = ep.config.database
+	}
+
+	format, err := detectDumpFormat(inputPath)
+	if err != nil {
+		return fmt.Errorf("unable to restore %s into database %s: %w", inputPath, database, err)
+	}
+
+	if opts.RecreateDatabase {
+		if database == ep.config.maintenanceDatabase {
+			return fmt.Errorf("the %s maintenance database cannot be recreated", ep.config.maintenanceDatabase)
+		}
+
+		if err := dropDatabase(ep.config, database); err != nil {
+			return fmt.Errorf("unable to recreate database %s: %w", database, err)
+		}
+
+		if err := ep.createDatabase(ep.config.connectionHost(), ep.config.port, ep.config.superuser(), ep.config.password, ep.config.maintenanceDatabase, database, ep.config.username); err != nil {
+			return fmt.Errorf("unable to recreate database %s: %w", database, err)
+		}
+	}
+
+	if format == DumpFormatCustom {
+		_, err = runClientTool(ep.config, "pg_restore", append(clientConnectionArgs(ep.config, database), "--exit-on-error", inputPath)...)
+	} else {
+		_, err = runClientTool(ep.config, "psql", append(clientConnectionArgs(ep.config, database), "--set", "ON_ERROR_STOP=1", "--quiet", "--file", inputPath)...)
+	}
+
+	if err != nil {
+		return fmt.Errorf("unable to restore %s into database %s: %w", inputPath, database, err)
+	}
+
+	return nil
+}
+
+// detectDumpFormat distinguishes custom format archives, which start with "PGDMP", from plain SQL dumps.
+func detectDumpFormat(path string) (DumpFormat, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+
+	defer func() {
+		if err := file.Close(); err != nil {
+			panic(err)
+		}
+	}()
+
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(file, header); err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+
+	if string(header) == "PGDMP" {
+		return DumpFormatCustom, nil
+	}
+
+	return DumpFormatPlain, nil
+}
+
+func dumpArgs(config Config, databaseName, outputPath string, opts DumpOptions) []string {
+	format := opts.Format
+	if format == "" {
+		format = DumpFormatPlain
+	}
+
+	return append(clientConnectionArgs(config, databaseName),
+		"--format", string(format),
+		"--file", outputPath,
+	)
+}
+
+// clientConnectionArgs returns the arguments connecting a client tool such as psql or pg_dump to databaseName.
+func clientConnectionArgs(config Config, databaseName string) []string {
+	return []string{
+		"--host", config.connectionHost(),
+		"--port", strconv.FormatUint(uint64(config.port), 10),
+		"--username", config.username,
+		"--dbname", databaseName,
+		"--no-password",
+	}
+}
+
+// runClientTool runs one of the bundled client tools with the configured password, returning its standard output.
+// On failure the tool's standard error is included in the returned error.
+func runClientTool(config Config, tool string, args ...string) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+
+	cmd := exec.Command(filepath.Join(config.binariesPath, "bin", tool), args...)
+	cmd.Env = append(config.processEnvironment(), "PGPASSWORD="+config.password)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if output := strings.TrimSpace(stderr.String()); output != "" {
+			return nil, fmt.Errorf("%s: %s", err, output)
+		}
+
+		return nil, err
+	}
+
+	return stdout.Bytes(), nil
+}
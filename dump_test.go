@@ -0,0 +1,155 @@
+package embeddedpostgres
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ErrorWhenDumpCalledBeforeStart(t *testing.T) {
+	database := NewDatabase()
+
+	err := database.Dump("postgres", "dump.sql", DumpOptions{})
+
+	assert.ErrorIs(t, err, ErrServerNotStarted)
+}
+
+func Test_ErrorWhenRestoreCalledBeforeStart(t *testing.T) {
+	database := NewDatabase()
+
+	err := database.Restore("dump.sql", RestoreOptions{})
+
+	assert.ErrorIs(t, err, ErrServerNotStarted)
+}
+
+func Test_ErrorWhenRestoreRecreatesMaintenanceDatabase(t *testing.T) {
+	dumpPath := filepath.Join(t.TempDir(), "dump.sql")
+	require.NoError(t, os.WriteFile(dumpPath, []byte("SELECT 1;"), 0600))
+
+	database := NewDatabase()
+	database.started = true
+
+	err := database.Restore(dumpPath, RestoreOptions{Database: "postgres", RecreateDatabase: true})
+
+	assert.EqualError(t, err, "the postgres maintenance database cannot be recreated")
+}
+
+func Test_detectDumpFormat(t *testing.T) {
+	plainDump, cleanUpPlain := writeFileWithBase64Content("dump_test*.sql", "Q1JFQVRFIFRBQkxFIGJlZXJzIChuYW1lIFRFWFQpOw==")
+	defer cleanUpPlain()
+
+	customDump, cleanUpCustom := writeFileWithBase64Content("dump_test*.pgdump", "UEdETVABDgAECAEBAQ==")
+	defer cleanUpCustom()
+
+	emptyDump, cleanUpEmpty := writeFileWithBase64Content("dump_test*.sql", "")
+	defer cleanUpEmpty()
+
+	format, err := detectDumpFormat(plainDump)
+	assert.NoError(t, err)
+	assert.Equal(t, DumpFormatPlain, format)
+
+	format, err = detectDumpFormat(customDump)
+	assert.NoError(t, err)
+	assert.Equal(t, DumpFormatCustom, format)
+
+	format, err = detectDumpFormat(emptyDump)
+	assert.NoError(t, err)
+	assert.Equal(t, DumpFormatPlain, format)
+
+	_, err = detectDumpFormat("/does-not-exist.sql")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func Test_dumpArgs(t *testing.T) {
+	config := DefaultConfig().Port(9876).Username("gin")
+
+	assert.Equal(t,
+		[]string{"--host", "localhost", "--port", "9876", "--username", "gin", "--dbname", "beer", "--no-password", "--format", "plain", "--file", "dump.sql"},
+		dumpArgs(config, "beer", "dump.sql", DumpOptions{}))
+	assert.Equal(t,
+		[]string{"--host", "localhost", "--port", "9876", "--username", "gin", "--dbname", "beer", "--no-password", "--format", "custom", "--file", "dump.pgdump"},
+		dumpArgs(config, "beer", "dump.pgdump", DumpOptions{Format: DumpFormatCustom}))
+}
+
+func Test_Dump(t *testing.T) {
+	outputDir, err := os.MkdirTemp("", "dump_test")
+	require.NoError(t, err)
+
+	defer func() {
+		if err := os.RemoveAll(outputDir); err != nil {
+			panic(err)
+		}
+	}()
+
+	database := NewDatabase(DefaultConfig().
+		InitSQL("CREATE TABLE beers (name TEXT)"))
+
+	if err := database.Start(); err != nil {
+		shutdownDBAndFatal(t, err, database)
+	}
+
+	plainDump := filepath.Join(outputDir, "dump.sql")
+	customDump := filepath.Join(outputDir, "dump.pgdump")
+
+	if err := database.Dump("postgres", plainDump, DumpOptions{}); err != nil {
+		shutdownDBAndFatal(t, err, database)
+	}
+
+	if err := database.Dump("postgres", customDump, DumpOptions{Format: DumpFormatCustom}); err != nil {
+		shutdownDBAndFatal(t, err, database)
+	}
+
+	if err := database.Stop(); err != nil {
+		shutdownDBAndFatal(t, err, database)
+	}
+
+	plainContent, err := os.ReadFile(plainDump)
+	assert.NoError(t, err)
+	assert.Contains(t, string(plainContent), "CREATE TABLE public.beers")
+
+	customContent, err := os.ReadFile(customDump)
+	assert.NoError(t, err)
+	assert.True(t, bytes.HasPrefix(customContent, []byte("PGDMP")))
+}
+
+func Test_Restore(t *testing.T) {
+	outputDir, err := os.MkdirTemp("", "restore_test")
+	require.NoError(t, err)
+
+	defer func() {
+		if err := os.RemoveAll(outputDir); err != nil {
+			panic(err)
+		}
+	}()
+
+	database := NewDatabase(DefaultConfig().
+		InitSQL("CREATE TABLE beers (name TEXT)", "INSERT INTO beers VALUES ('stout')"))
+
+	if err := database.Start(); err != nil {
+		shutdownDBAndFatal(t, err, database)
+	}
+
+	for _, format := range []DumpFormat{DumpFormatPlain, DumpFormatCustom} {
+		dumpPath := filepath.Join(outputDir, "dump_"+string(format))
+
+		if err := database.Dump("postgres", dumpPath, DumpOptions{Format: format}); err != nil {
+			shutdownDBAndFatal(t, err, database)
+		}
+
+		if err := database.Restore(dumpPath, RestoreOptions{Database: "restored", RecreateDatabase: true}); err != nil {
+			shutdownDBAndFatal(t, err, database)
+		}
+	}
+
+	err = database.Restore(filepath.Join(outputDir, "dump_plain"), RestoreOptions{Database: "restored"})
+
+	if err := database.Stop(); err != nil {
+		shutdownDBAndFatal(t, err, database)
+	}
+
+	assert.ErrorContains(t, err, `relation "beers" already exists`)
+}
@@ -1,33 +1,143 @@
 package embeddedpostgres
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"io"
+	"math"
 	"net"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
-var mu sync.Mutex
+// cacheLocks holds a *sync.Mutex per cache location, serialising downloads and extraction of the same archive while
+// allowing unrelated versions and caches to be provisioned in parallel.
+//
+//nolint:gochecknoglobals
+var cacheLocks sync.Map
+
+// fileLockPollInterval is how often a lock held by another process is retried.
+const fileLockPollInterval = 100 * time.Millisecond
+
+// lockCacheLocation locks the given cache location against other goroutines and, through a lock file next to the
+// archive, other processes. It returns the function which unlocks it.
+func lockCacheLocation(ctx context.Context, cacheLocation string) (func(), error) {
+	lock, _ := cacheLocks.LoadOrStore(cacheLocation, &sync.Mutex{})
+	mutex := lock.(*sync.Mutex)
+	mutex.Lock()
+
+	// without a location there is nowhere to put the lock file
+	if cacheLocation == "" {
+		return mutex.Unlock, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(cacheLocation), 0755); err != nil {
+		mutex.Unlock()
+		return nil, fmt.Errorf("unable to lock cache %s: %w", cacheLocation, err)
+	}
+
+	unlockFile, err := lockFile(ctx, cacheLocation+".lock")
+	if err != nil {
+		mutex.Unlock()
+
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		return nil, fmt.Errorf("unable to lock cache %s: %w", cacheLocation, err)
+	}
+
+	return func() {
+		unlockFile()
+		mutex.Unlock()
+	}, nil
+}
 
 var (
 	ErrServerNotStarted     = errors.New("server has not been started")
 	ErrServerAlreadyStarted = errors.New("server is already started")
+	ErrNoSnapshot           = errors.New("no snapshot has been taken")
+)
+
+// Stages of Start reported by StartError.
+const (
+	StageDownload    = "download"
+	StageInit        = "init"
+	StageStart       = "start"
+	StageCreate      = "create"
+	StageHealthCheck = "healthcheck"
+	StageReady       = "ready"
+)
+
+// Phase is a step of the lifecycle of Postgres reported to the OnPhase callback.
+type Phase string
+
+// Phases reported to OnPhase, in the order they are entered. Phases which Start skips, e.g. downloading when the
+// binaries are already cached or initializing when an existing data directory is reused, are not reported.
+const (
+	PhaseDownloading  Phase = "downloading"
+	PhaseExtracting   Phase = "extracting"
+	PhaseInitializing Phase = "initializing"
+	PhaseStarting     Phase = "starting"
+	PhaseReady        Phase = "ready"
+	PhaseStopping     Phase = "stopping"
+	PhaseStopped      Phase = "stopped"
 )
 
+// StartError is returned by Start when a stage of starting Postgres fails.
+// Log holds the full Postgres output captured up to the failure.
+type StartError struct {
+	Stage string
+	Log   string
+	Err   error
+}
+
+func (e *StartError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *StartError) Unwrap() error {
+	return e.Err
+}
+
+// StartTimings records how long each phase of the most recent Start took. A phase that was skipped, e.g. because the
+// binaries were already cached or an existing data directory was reused, has a zero duration.
+type StartTimings struct {
+	Download     time.Duration
+	Extract      time.Duration
+	InitDB       time.Duration
+	CreateDB     time.Duration
+	StartProcess time.Duration
+	HealthCheck  time.Duration
+}
+
 // EmbeddedPostgres maintains all configuration and runtime functions for maintaining the lifecycle of one Postgres process.
 type EmbeddedPostgres struct {
 	config              Config
+	versionStrategy     VersionStrategy
 	cacheLocator        CacheLocator
 	remoteFetchStrategy RemoteFetchStrategy
+	streamFetchStrategy func(extractPath string) RemoteFetchStrategy
+	extractor           Extractor
 	initDatabase        initDatabase
 	createDatabase      createDatabase
 	started             bool
 	syncedLogger        *syncedLogger
+	logChannel          *channelWriter
+	stopFollowingLogs   func()
+	tlsCertificate      []byte
+	processWatcher      *processWatcher
+	timings             StartTimings
+	dataReused          bool
+	snapshotPath        string
+	configErr           error
 }
 
 // NewDatabase creates a new EmbeddedPostgres struct that can be used to start and stop a Postgres process.
@@ -42,6 +152,8 @@ func NewDatabase(config ...Config) *EmbeddedPostgres {
 }
 
 func newDatabaseWithConfig(config Config) *EmbeddedPostgres {
+	config.libraryLog = &libraryLog{}
+
 	versionStrategy := defaultVersionStrategy(
 		config,
 		runtime.GOOS,
@@ -49,222 +161,1411 @@ func newDatabaseWithConfig(config Config) *EmbeddedPostgres {
 		linuxMachineName,
 		shouldUseAlpineLinuxBuild,
 	)
-	cacheLocator := defaultCacheLocator(config.cachePath, versionStrategy)
-	remoteFetchStrategy := defaultRemoteFetchStrategy(config.binaryRepositoryURL, versionStrategy, cacheLocator)
+	cacheLocator := config.cacheLocator
+	if cacheLocator == nil {
+		cacheLocator = defaultCacheLocator(config.cachePath, versionStrategy)
+	}
+	remoteFetchStrategy := config.fetchStrategy
+	if remoteFetchStrategy == nil {
+		remoteFetchStrategy = defaultRemoteFetchStrategy(config, versionStrategy, cacheLocator)
+	}
+	var streamFetchStrategy func(extractPath string) RemoteFetchStrategy
+	if config.streamExtract {
+		streamFetchStrategy = defaultStreamFetchStrategy(config, versionStrategy)
+	}
+	extractor := config.extractor
+	if extractor == nil {
+		extractor = defaultExtractor(config)
+	}
 
 	return &EmbeddedPostgres{
 		config:              config,
+		versionStrategy:     versionStrategy,
 		cacheLocator:        cacheLocator,
 		remoteFetchStrategy: remoteFetchStrategy,
+		streamFetchStrategy: streamFetchStrategy,
+		extractor:           extractor,
 		initDatabase:        defaultInitDatabase,
 		createDatabase:      defaultCreateDatabase,
 		started:             false,
+		configErr:           config.Validate(),
 	}
 }
 
 // Start will try to start the configured Postgres process returning an error when there were any problems with invocation.
 // If any error occurs Start will try to also Stop the Postgres process in order to not leave any sub-process running.
-//
-//nolint:funlen
 func (ep *EmbeddedPostgres) Start() error {
+	return ep.StartWithContext(context.Background())
+}
+
+// EnsureStarted starts the Postgres process unless it has already been started, in which case it returns nil rather
+// than ErrServerAlreadyStarted.
+func (ep *EmbeddedPostgres) EnsureStarted() error {
+	if ep.started {
+		return nil
+	}
+
+	return ep.Start()
+}
+
+// StartWithContext behaves as Start but aborts downloading, extracting, initialising and waiting for Postgres to
+// become available when the context is cancelled, returning ctx.Err().
+func (ep *EmbeddedPostgres) StartWithContext(ctx context.Context) error {
 	if ep.started {
 		return ErrServerAlreadyStarted
 	}
 
-	if err := ensurePortAvailable(ep.config.port); err != nil {
+	if err := ep.start(ctx); err != nil {
+		// nothing more is logged, consumers ranging over the LogChannel must not wait for Stop
+		ep.closeLogChannel()
+		return err
+	}
+
+	return nil
+}
+
+//nolint:funlen
+func (ep *EmbeddedPostgres) start(ctx context.Context) error {
+	if ep.configErr != nil {
+		return ep.configErr
+	}
+
+	if ep.config.overallTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, ep.config.overallTimeout)
+		defer cancel()
+	}
+
+	ep.timings = StartTimings{}
+	ep.dataReused = false
+
+	if err := checkTLSFiles(ep.config); err != nil {
+		return err
+	}
+
+	if err := checkRunAsUser(ep.config); err != nil {
 		return err
 	}
 
-	logger, err := newSyncedLogger("", ep.config.logger)
+	if ep.config.configFile != "" {
+		if _, err := os.Stat(ep.config.configFile); err != nil {
+			return fmt.Errorf("unable to use config file %s: %w", ep.config.configFile, err)
+		}
+	}
+
+	if ep.config.hbaFile != "" {
+		if _, err := os.Stat(ep.config.hbaFile); err != nil {
+			return fmt.Errorf("unable to use HBA file %s: %w", ep.config.hbaFile, err)
+		}
+	}
+
+	// without TCP the port only names the socket, a free port is still chosen when none is configured
+	if !ep.config.disableTCP || ep.config.port == 0 {
+		address := ep.config.listenAddress()
+		if ep.config.disableTCP {
+			address = "localhost"
+		}
+
+		port, err := ensurePortAvailable(address, ep.config.port)
+		if err != nil && ep.config.autoPortFallback {
+			port, err = ensurePortAvailable(address, 0)
+		}
+
+		if err != nil {
+			return err
+		}
+
+		ep.config.port = port
+	}
+
+	logWriter := ep.config.logger
+	if ep.config.logLine != nil {
+		logWriter = newLineWriter(ep.config.logLine)
+	}
+
+	if ep.config.logChannel != nil {
+		if ep.logChannel == nil {
+			ep.logChannel = newChannelWriter(ep.config.logChannel, ep.config.logChannelBlocking)
+		}
+
+		if logWriter == nil {
+			logWriter = ep.logChannel
+		} else {
+			logWriter = io.MultiWriter(logWriter, ep.logChannel)
+		}
+	}
+
+	if ep.config.logLevel != "" && logWriter != nil {
+		logWriter = newLevelWriter(logWriter, ep.config.logLevel)
+	}
+
+	logger, err := newSyncedLogger("", logWriter)
 	if err != nil {
 		return errors.New("unable to create logger")
 	}
 
+	if ep.config.stderrLogger != nil {
+		stderrWriter := ep.config.stderrLogger
+		if ep.config.logLevel != "" {
+			stderrWriter = newLevelWriter(stderrWriter, ep.config.logLevel)
+		}
+
+		if err := logger.separateStderr(stderrWriter); err != nil {
+			return errors.New("unable to create stderr logger")
+		}
+	}
+
 	ep.syncedLogger = logger
+	ep.config.libraryLog.setLogger(logger)
+
+	if err := ep.resolveVersion(ctx); err != nil {
+		return ep.startError(StageDownload, err)
+	}
 
 	cacheLocation, cacheExists := ep.cacheLocator()
 
 	if ep.config.runtimePath == "" {
-		ep.config.runtimePath = filepath.Join(filepath.Dir(cacheLocation), "extracted")
+		ep.config.runtimePath = ep.config.defaultRuntimePath(cacheLocation)
 	}
 
 	if ep.config.dataPath == "" {
 		ep.config.dataPath = filepath.Join(ep.config.runtimePath, "data")
 	}
 
-	if err := os.RemoveAll(ep.config.runtimePath); err != nil {
-		return fmt.Errorf("unable to clean up runtime directory %s with error: %s", ep.config.runtimePath, err)
+	// a data directory within the runtime directory is always removed, other than by a dry run which leaves it in place
+	dataIsRemoved := isWithinDir(ep.config.runtimePath, ep.config.dataPath)
+
+	switch {
+	case ep.config.dryRun:
+		if dataIsRemoved {
+			ep.config.logf("dry run: removing data directory %s", ep.config.dataPath)
+		}
+	case ep.config.reuseRuntime && runtimeIsReusable(ep.config.runtimePath, ep.config.version):
+		if dataIsRemoved {
+			if err := ep.removeDataDirectory(); err != nil {
+				return err
+			}
+		}
+	default:
+		if dataIsRemoved {
+			if err := removeTablespaceDirectories(ep.config, ep.config.dataPath); err != nil {
+				return err
+			}
+		}
+
+		if err := os.RemoveAll(ep.config.runtimePath); err != nil {
+			return fmt.Errorf("unable to clean up runtime directory %s with error: %s", ep.config.runtimePath, err)
+		}
 	}
 
 	if ep.config.binariesPath == "" {
 		ep.config.binariesPath = ep.config.runtimePath
 	}
 
-	if err := ep.downloadAndExtractBinary(cacheExists, cacheLocation); err != nil {
-		return err
+	if err := ep.downloadAndExtractBinary(ctx, cacheExists, cacheLocation); err != nil {
+		return ep.startError(StageDownload, err)
+	}
+
+	if err := checkTimeZone(ep.config.binariesPath, ep.config.timeZone); err != nil {
+		return ep.startError(StageInit, err)
+	}
+
+	if ep.config.initDBPath != "" {
+		if _, err := exec.LookPath(ep.config.initDBPath); err != nil {
+			return ep.startError(StageInit, fmt.Errorf("invalid InitDBPath %s: %w", ep.config.initDBPath, err))
+		}
+	}
+
+	if warning := ep.config.sharedBuffersWarning(); warning != "" {
+		ep.config.logf("%s", warning)
 	}
 
 	if err := os.MkdirAll(ep.config.runtimePath, os.ModePerm); err != nil {
 		return fmt.Errorf("unable to create runtime directory %s with error: %s", ep.config.runtimePath, err)
 	}
 
-	reuseData := dataDirIsValid(ep.config.dataPath, ep.config.version)
+	if err := chownToRunAsUser(ep.config, ep.config.runtimePath); err != nil {
+		return err
+	}
+
+	if err := ep.createSocketDir(); err != nil {
+		return err
+	}
+
+	ep.config.logf("using runtime directory %s and data directory %s", ep.RuntimePath(), ep.DataPath())
+
+	reuseData := !(ep.config.dryRun && dataIsRemoved) && dataDirIsValid(ep.config.dataPath, ep.config.version)
+	ep.dataReused = reuseData
+
+	if reuseData && ep.config.dataChecksums {
+		ep.config.logf("DataChecksums is ignored as existing data directory %s is being reused", ep.config.dataPath)
+	}
+
+	cloneTemplate := !reuseData && ep.config.templateDataPath != ""
+	standby := ep.config.standbyPrimaryConnInfo != ""
+
+	if ep.config.dryRun {
+		return ep.dryRunStart(ctx, reuseData, cloneTemplate, standby)
+	}
 
 	if !reuseData {
-		if err := ep.cleanDataDirectoryAndInit(); err != nil {
-			return err
+		ep.enterPhase(PhaseInitializing)
+
+		initStarted := time.Now()
+
+		initialise := func() error {
+			return ep.cleanDataDirectoryAndInit(ctx)
+		}
+
+		switch {
+		case cloneTemplate:
+			initialise = ep.cleanDataDirectoryAndCloneTemplate
+		case standby:
+			initialise = func() error {
+				return ep.cleanDataDirectoryAndBaseBackup(ctx)
+			}
 		}
+
+		if err := initialise(); err != nil {
+			return ep.startError(StageInit, err)
+		}
+
+		ep.timings.InitDB = time.Since(initStarted)
 	}
 
-	if err := startPostgres(ep); err != nil {
-		return err
+	if standby {
+		if err := writeStandbyFiles(ep.config); err != nil {
+			return ep.startError(StageInit, err)
+		}
+	}
+
+	if ep.config.tlsEnabled() {
+		certificate, err := installTLSFiles(ep.config)
+		if err != nil {
+			return ep.startError(StageInit, err)
+		}
+
+		ep.tlsCertificate = certificate
+	}
+
+	// a cloned template and TLS files are written as the current user
+	if err := chownToRunAsUser(ep.config, ep.config.dataPath); err != nil {
+		return ep.startError(StageInit, err)
+	}
+
+	if err := ep.removeStalePostmasterPID(); err != nil {
+		return ep.startError(StageStart, err)
+	}
+
+	ep.enterPhase(PhaseStarting)
+
+	processStarted := time.Now()
+
+	if err := startPostgres(ctx, ep); err != nil {
+		// pg_ctl may have been abandoned while waiting, e.g. when ctx expired, leaving the postmaster running
+		if pid, pidErr := readPostmasterPID(ep.config.dataPath); pidErr == nil && processExists(pid) {
+			return ep.stopAfterError(StageStart, err)
+		}
+
+		return ep.startError(StageStart, err)
 	}
 
+	ep.timings.StartProcess = time.Since(processStarted)
+
 	if err := ep.syncedLogger.flush(); err != nil {
 		return err
 	}
 
 	ep.started = true
 
-	if !reuseData {
-		if err := ep.createDatabase(ep.config.port, ep.config.username, ep.config.password, ep.config.database); err != nil {
-			if stopErr := stopPostgres(ep); stopErr != nil {
-				return fmt.Errorf("unable to stop database caused by error %s", err)
+	if ep.logChannel != nil {
+		ep.stopFollowingLogs = ep.syncedLogger.follow()
+	}
+
+	// a standby is read-only, its databases are those of the primary
+	if !reuseData && !cloneTemplate && !standby {
+		if err := ctx.Err(); err != nil {
+			return ep.stopAfterError(StageCreate, err)
+		}
+
+		createStarted := time.Now()
+
+		if ep.config.superuser() != ep.config.username {
+			if err := createRole(ep.config); err != nil {
+				return ep.stopAfterError(StageCreate, err)
 			}
+		}
 
-			return err
+		for _, database := range ep.config.initDatabaseNames() {
+			if err := ep.createDatabase(ep.config.connectionHost(), ep.config.port, ep.config.superuser(), ep.config.password, ep.config.maintenanceDatabase, database, ep.config.username); err != nil {
+				return ep.stopAfterError(StageCreate, err)
+			}
+		}
+
+		if len(ep.config.roles) > 0 {
+			if err := createRoles(ep.config); err != nil {
+				return ep.stopAfterError(StageCreate, err)
+			}
+		}
+
+		if len(ep.config.tablespaces) > 0 {
+			if err := createTablespaces(ep.config); err != nil {
+				return ep.stopAfterError(StageCreate, err)
+			}
+		}
+
+		if len(ep.config.extensions) > 0 {
+			if err := createExtensions(ep.config); err != nil {
+				return ep.stopAfterError(StageCreate, err)
+			}
+		}
+
+		if err := runInitScripts(ep.config); err != nil {
+			return ep.stopAfterError(StageCreate, err)
+		}
+
+		if ep.config.analyzeOnStart {
+			if err := analyzeDatabase(ep.config); err != nil {
+				return ep.stopAfterError(StageCreate, err)
+			}
+		}
+
+		ep.timings.CreateDB = time.Since(createStarted)
+	}
+
+	healthCheckStarted := time.Now()
+
+	if err := healthCheckDatabaseOrTimeout(ctx, ep.config); err != nil {
+		return ep.stopAfterError(StageHealthCheck, err)
+	}
+
+	ep.timings.HealthCheck = time.Since(healthCheckStarted)
+
+	if ep.config.onReady != nil {
+		if err := runCallback(ep.config, ep.config.onReady); err != nil {
+			return ep.stopAfterError(StageReady, err)
 		}
 	}
 
-	if err := healthCheckDatabaseOrTimeout(ep.config); err != nil {
-		if stopErr := stopPostgres(ep); stopErr != nil {
-			return fmt.Errorf("unable to stop database caused by error %s", err)
+	if ep.config.onUnexpectedExit != nil {
+		pid, err := readPostmasterPID(ep.config.dataPath)
+		if err != nil {
+			return ep.stopAfterError(StageReady, fmt.Errorf("unable to watch postgres process: %w", err))
 		}
 
-		return err
+		ep.processWatcher = watchProcess(pid, ep.config.onUnexpectedExit)
 	}
 
+	ep.enterPhase(PhaseReady)
+
 	return nil
 }
 
-func (ep *EmbeddedPostgres) downloadAndExtractBinary(cacheExists bool, cacheLocation string) error {
-	// lock to prevent collisions with duplicate downloads
-	mu.Lock()
-	defer mu.Unlock()
+// enterPhase reports phase to the OnPhase callback, if any.
+func (ep *EmbeddedPostgres) enterPhase(phase Phase) {
+	if ep.config.onPhase != nil {
+		ep.config.onPhase(phase)
+	}
+}
 
-	_, binDirErr := os.Stat(filepath.Join(ep.config.binariesPath, "bin", "pg_ctl"))
-	if os.IsNotExist(binDirErr) {
-		if !cacheExists {
-			if err := ep.remoteFetchStrategy(); err != nil {
-				return err
+// dryRunStart logs the commands and statements Start would run to initialise and start Postgres and create the
+// databases, without running them or touching the data directory.
+func (ep *EmbeddedPostgres) dryRunStart(ctx context.Context, reuseData, cloneTemplate, standby bool) error {
+	switch {
+	case reuseData:
+		ep.config.logf("dry run: reusing data directory %s", ep.config.dataPath)
+	case cloneTemplate:
+		ep.config.logf("dry run: copying template data directory %s to %s", ep.config.templateDataPath, ep.config.dataPath)
+	case standby:
+		ep.config.logf("dry run: %s", redactedBaseBackupCommand(ep.config))
+	default:
+		ep.config.logf("dry run: %s", initDBCommand(ctx, ep.config.initDBExecutable(), ep.config.dataPath, ep.config.superuser(),
+			passwordFilePath(ep.config.runtimePath), ep.config.locale, ep.config.encoding, ep.config.initDBArgs()))
+	}
+
+	ep.config.logf("dry run: %s", startCommand(ctx, ep.config))
+
+	if !reuseData && !cloneTemplate && !standby {
+		for _, database := range ep.config.initDatabaseNames() {
+			if statement := createDatabaseStatement(ep.config.superuser(), ep.config.maintenanceDatabase, database, ep.config.username); statement != "" {
+				ep.config.logf("dry run: %s", statement)
 			}
 		}
 
-		if err := decompressTarXz(defaultTarReader, cacheLocation, ep.config.binariesPath); err != nil {
-			return err
+		for _, name := range ep.config.tablespaceNames() {
+			ep.config.logf("dry run: %s", createTablespaceStatement(ep.config, name, absolutePath(ep.config.tablespaces[name])))
+		}
+
+		if ep.config.analyzeOnStart {
+			ep.config.logf("dry run: %s", analyzeStatement)
 		}
 	}
+
+	ep.started = true
+
+	ep.enterPhase(PhaseReady)
+
 	return nil
 }
 
-func (ep *EmbeddedPostgres) cleanDataDirectoryAndInit() error {
-	if err := os.RemoveAll(ep.config.dataPath); err != nil {
-		return fmt.Errorf("unable to clean up data directory %s with error: %s", ep.config.dataPath, err)
-	}
+// DataWasReused reports whether the most recent Start reused an existing data directory rather than initialising a
+// fresh one or cloning TemplateDataPath. Databases, extensions and init scripts are only created on a fresh data
+// directory, so callers persisting DataPath can use it to decide whether to seed or migrate.
+func (ep *EmbeddedPostgres) DataWasReused() bool {
+	return ep.dataReused
+}
 
-	if err := ep.initDatabase(ep.config.binariesPath, ep.config.runtimePath, ep.config.dataPath, ep.config.username, ep.config.password, ep.config.locale, ep.config.encoding, ep.syncedLogger.file); err != nil {
-		return err
+// Timings returns how long each phase of the most recent Start took.
+func (ep *EmbeddedPostgres) Timings() StartTimings {
+	return ep.timings
+}
+
+// stopAfterError stops Postgres after a failure in Start, returning the error to be reported.
+func (ep *EmbeddedPostgres) stopAfterError(stage string, err error) error {
+	ep.stopLogFollowing()
+
+	// the caller's context may already be done, stopping must not be abandoned
+	if stopErr := stopPostgres(context.Background(), ep); stopErr != nil {
+		return ep.startError(stage, fmt.Errorf("unable to stop database caused by error %s", err))
 	}
 
-	return nil
+	ep.started = false
+
+	return ep.startError(stage, err)
 }
 
-// Stop will try to stop the Postgres process gracefully returning an error when there were any problems.
-func (ep *EmbeddedPostgres) Stop() error {
-	if !ep.started {
-		return ErrServerNotStarted
+// closeLogChannel closes the configured LogChannel, if it is not closed already.
+func (ep *EmbeddedPostgres) closeLogChannel() {
+	if ep.config.logChannel == nil {
+		return
 	}
 
-	if err := stopPostgres(ep); err != nil {
-		return err
+	if ep.logChannel == nil {
+		ep.logChannel = newChannelWriter(ep.config.logChannel, ep.config.logChannelBlocking)
 	}
 
-	ep.started = false
+	ep.logChannel.close()
+}
 
-	if err := ep.syncedLogger.flush(); err != nil {
-		return err
+// stopLogFollowing stops forwarding Postgres output while it runs, if LogChannel is configured.
+func (ep *EmbeddedPostgres) stopLogFollowing() {
+	if ep.stopFollowingLogs != nil {
+		ep.stopFollowingLogs()
+		ep.stopFollowingLogs = nil
 	}
-
-	return nil
 }
 
-func encodeOptions(port uint32, parameters map[string]string) string {
-	options := []string{fmt.Sprintf("-p %d", port)}
-	for k, v := range parameters {
-		// Double-quote parameter values - they may have spaces.
-		// Careful: CMD on Windows uses only double quotes to delimit strings.
-		// It treats single quotes as regular characters.
-		options = append(options, fmt.Sprintf("-c %s=\"%s\"", k, v))
+// startError wraps err in a StartError for the given stage, attaching the Postgres log captured so far.
+func (ep *EmbeddedPostgres) startError(stage string, err error) error {
+	startErr := &StartError{Stage: stage, Err: err}
+
+	if ep.syncedLogger != nil {
+		if logContent, readErr := ep.syncedLogger.readLogs(); readErr == nil {
+			startErr.Log = string(logContent)
+		}
 	}
-	return strings.Join(options, " ")
+
+	return startErr
 }
 
-func startPostgres(ep *EmbeddedPostgres) error {
-	postgresBinary := filepath.Join(ep.config.binariesPath, "bin/pg_ctl")
-	postgresProcess := exec.Command(postgresBinary, "start", "-w",
-		"-D", ep.config.dataPath,
-		"-o", encodeOptions(ep.config.port, ep.config.startParameters))
-	postgresProcess.Stdout = ep.syncedLogger.file
-	postgresProcess.Stderr = ep.syncedLogger.file
-	applyPlatformSpecificOptions(postgresProcess, ep.config)
+// Prepare downloads the Postgres binaries into the cache without starting Postgres, e.g. to warm the cache during a
+// Docker build. If BinariesPath is set the binaries are also extracted there. Prepare does nothing if the binaries
+// are already available.
+func (ep *EmbeddedPostgres) Prepare() error {
+	return ep.PrepareWithContext(context.Background())
+}
 
-	if err := postgresProcess.Run(); err != nil {
-		_ = ep.syncedLogger.flush()
-		logContent, _ := readLogsOrTimeout(ep.syncedLogger.file)
+// PrepareWithContext behaves as Prepare but aborts when the context is cancelled, returning ctx.Err().
+func (ep *EmbeddedPostgres) PrepareWithContext(ctx context.Context) error {
+	if ep.configErr != nil {
+		return ep.configErr
+	}
 
-		return fmt.Errorf("could not start postgres using %s:\n%s", postgresProcess.String(), string(logContent))
+	if err := ep.resolveVersion(ctx); err != nil {
+		return err
 	}
 
-	return nil
-}
+	cacheLocation, cacheExists := ep.cacheLocator()
 
-func stopPostgres(ep *EmbeddedPostgres) error {
-	postgresBinary := filepath.Join(ep.config.binariesPath, "bin/pg_ctl")
-	postgresProcess := exec.Command(postgresBinary, "stop", "-w",
-		"-D", ep.config.dataPath)
-	postgresProcess.Stderr = ep.syncedLogger.file
-	postgresProcess.Stdout = ep.syncedLogger.file
-	applyPlatformSpecificOptions(postgresProcess, ep.config)
+	if ep.config.binariesPath != "" {
+		return ep.downloadAndExtractBinary(ctx, cacheExists, cacheLocation)
+	}
 
-	if err := postgresProcess.Run(); err != nil {
+	if cacheExists {
+		return nil
+	}
+
+	unlock, err := lockCacheLocation(ctx, cacheLocation)
+	if err != nil {
 		return err
 	}
 
-	return nil
+	defer unlock()
+
+	// another caller may have fetched the archive while we waited for the lock
+	if _, cacheExists = ep.cacheLocator(); cacheExists {
+		return nil
+	}
+
+	return ep.fetch(ctx, ep.remoteFetchStrategy)
 }
 
-func ensurePortAvailable(port uint32) error {
-	conn, err := net.Listen("tcp", fmt.Sprintf("localhost:%d", port))
-	if err != nil {
-		return fmt.Errorf("process already listening on port %d", port)
+// resolveVersion looks up the newest patch release of a partial Version from BinaryRepositoryURL, so that the binaries
+// are located and fetched by the full version. Nothing is looked up offline, when the version is resolved from the
+// cache instead, or when both the cache locator and the fetch strategy are custom.
+func (ep *EmbeddedPostgres) resolveVersion(ctx context.Context) error {
+	if !isPartialVersion(ep.config.version) || ep.config.offline || (ep.config.cacheLocator != nil && ep.config.fetchStrategy != nil) {
+		return nil
 	}
 
-	if err := conn.Close(); err != nil {
-		return err
+	operatingSystem, architecture, _ := ep.versionStrategy()
+
+	if _, err := resolveLatestPatchVersion(ctx, ep.config, operatingSystem, architecture); err != nil {
+		return fmt.Errorf("unable to resolve Postgres version %s: %w", ep.config.version, err)
 	}
 
 	return nil
 }
 
-func dataDirIsValid(dataDir string, version PostgresVersion) bool {
-	pgVersion := filepath.Join(dataDir, "PG_VERSION")
+func (ep *EmbeddedPostgres) fetch(ctx context.Context, fetchStrategy RemoteFetchStrategy) error {
+	if ep.config.offline {
+		cacheLocation, _ := ep.cacheLocator()
+		return fmt.Errorf("offline mode is enabled and no Postgres %s binaries were found in cache %s or BinariesPath %s",
+			ep.config.version,
+			cacheLocation,
+			ep.config.binariesPath,
+		)
+	}
 
-	d, err := os.ReadFile(pgVersion)
-	if err != nil {
-		return false
+	err := fetchStrategy(ctx)
+	if errors.Is(err, errVersionNotFound) && !isSupportedVersion(ep.config.version) {
+		return fmt.Errorf("%w: %s is not one of SupportedVersions() and may not have been published", err, ep.config.version)
 	}
 
-	v := strings.TrimSuffix(string(d), "\n")
+	return err
+}
+
+func (ep *EmbeddedPostgres) downloadAndExtractBinary(ctx context.Context, cacheExists bool, cacheLocation string) error {
+	// lock to prevent collisions with duplicate downloads
+	unlock, err := lockCacheLocation(ctx, cacheLocation)
+	if err != nil {
+		return err
+	}
+
+	defer unlock()
+
+	_, binDirErr := os.Stat(filepath.Join(ep.config.binariesPath, "bin", "pg_ctl"))
+	extract := os.IsNotExist(binDirErr)
+
+	if binDirErr == nil {
+		if extract, err = ep.removeMismatchedBinaries(); err != nil {
+			return err
+		}
+	}
+
+	if extract {
+		// another caller may have fetched the archive while we waited for the lock
+		if !cacheExists {
+			_, cacheExists = ep.cacheLocator()
+		}
+
+		if !cacheExists && ep.streamFetchStrategy != nil {
+			// the archive is extracted as it is downloaded, so there is no separate extracting phase
+			ep.enterPhase(PhaseDownloading)
+
+			downloadStarted := time.Now()
+
+			if err := ep.fetch(ctx, ep.streamFetchStrategy(ep.config.binariesPath)); err != nil {
+				return err
+			}
+
+			// downloading and extracting are a single step
+			ep.timings.Download = time.Since(downloadStarted)
+
+			return writeExtractedMarker(ep.config)
+		}
+
+		if !cacheExists {
+			ep.enterPhase(PhaseDownloading)
+
+			downloadStarted := time.Now()
+
+			if err := ep.fetch(ctx, ep.remoteFetchStrategy); err != nil {
+				return err
+			}
+
+			ep.timings.Download = time.Since(downloadStarted)
+		}
+
+		ep.enterPhase(PhaseExtracting)
+
+		extractStarted := time.Now()
+
+		if err := ep.extractor.Extract(ctx, cacheLocation, ep.config.binariesPath); err != nil {
+			return err
+		}
+
+		ep.timings.Extract = time.Since(extractStarted)
+
+		return writeExtractedMarker(ep.config)
+	}
+	return nil
+}
+
+// removeMismatchedBinaries removes the binaries extracted in BinariesPath when pg_ctl reports a version other than the
+// configured one, e.g. after switching versions with a fixed BinariesPath, reporting whether they were removed and
+// need extracting again. Binaries whose version cannot be read are kept as they are. Binaries which were not
+// extracted by Start or Prepare, such as an existing installation, are never removed, a differing version is reported
+// as an error instead.
+func (ep *EmbeddedPostgres) removeMismatchedBinaries() (bool, error) {
+	binariesVersion, err := readBinariesVersion(ep.config.binariesPath)
+	if err != nil || binariesMatchVersion(binariesVersion, ep.config.version) {
+		return false, nil
+	}
+
+	markerPath := filepath.Join(ep.config.binariesPath, extractedMarkerName)
+	if _, err := os.Stat(markerPath); err != nil {
+		return false, fmt.Errorf("the Postgres %s binaries in %s differ from the configured version %s and were not extracted by embedded-postgres, so they are left in place",
+			binariesVersion, ep.config.binariesPath, ep.config.version)
+	}
+
+	ep.config.logf("removing Postgres %s binaries from %s to extract version %s", binariesVersion, ep.config.binariesPath, ep.config.version)
+
+	for _, name := range append(extractedBinariesDirs(), extractedMarkerName) {
+		if err := os.RemoveAll(filepath.Join(ep.config.binariesPath, name)); err != nil {
+			return false, fmt.Errorf("unable to remove Postgres %s binaries from %s with error: %s", binariesVersion, ep.config.binariesPath, err)
+		}
+	}
+
+	return true, nil
+}
+
+// extractedBinariesDirs returns the top level directories of the binaries archive.
+func extractedBinariesDirs() []string {
+	return []string{"bin", "include", "lib", "share"}
+}
+
+// extractedMarkerName is the file written to BinariesPath once the binaries have been extracted there, recording
+// the version extracted, so that only binaries extracted by this library are ever removed.
+const extractedMarkerName = ".embedded-postgres-version"
+
+func writeExtractedMarker(config Config) error {
+	markerPath := filepath.Join(config.binariesPath, extractedMarkerName)
+
+	if err := os.WriteFile(markerPath, []byte(config.version), 0600); err != nil {
+		return fmt.Errorf("unable to write %s with error: %s", markerPath, err)
+	}
+
+	return nil
+}
+
+// createSocketDir creates SocketDir when it does not exist, giving it to RunAsUser. An existing directory is used as
+// it is, as it may be shared such as /tmp.
+func (ep *EmbeddedPostgres) createSocketDir() error {
+	if ep.config.socketDir == "" {
+		return nil
+	}
+
+	if _, err := os.Stat(ep.config.socketDir); err == nil {
+		return nil
+	}
+
+	if err := os.MkdirAll(ep.config.socketDir, 0700); err != nil {
+		return fmt.Errorf("unable to create socket directory %s with error: %s", ep.config.socketDir, err)
+	}
+
+	return chownToRunAsUser(ep.config, ep.config.socketDir)
+}
+
+// removeDataDirectory removes the data directory together with what it holds in the configured Tablespaces.
+func (ep *EmbeddedPostgres) removeDataDirectory() error {
+	if err := removeTablespaceDirectories(ep.config, ep.config.dataPath); err != nil {
+		return err
+	}
+
+	if err := os.RemoveAll(ep.config.dataPath); err != nil {
+		return fmt.Errorf("unable to clean up data directory %s with error: %s", ep.config.dataPath, err)
+	}
+
+	return nil
+}
+
+// cleanDataDirectory removes the data directory so that it can be created afresh, creating it empty for RunAsUser.
+func (ep *EmbeddedPostgres) cleanDataDirectory() error {
+	if err := ep.removeDataDirectory(); err != nil {
+		return err
+	}
+
+	if ep.config.runAsUser != "" {
+		// initdb running as RunAsUser may not be able to create the data directory itself
+		if err := os.MkdirAll(ep.config.dataPath, 0700); err != nil {
+			return fmt.Errorf("unable to create data directory %s with error: %s", ep.config.dataPath, err)
+		}
+
+		if err := chownToRunAsUser(ep.config, ep.config.dataPath); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (ep *EmbeddedPostgres) cleanDataDirectoryAndInit(ctx context.Context) error {
+	if err := ep.cleanDataDirectory(); err != nil {
+		return err
+	}
+
+	if ep.config.initDBLogger == nil {
+		return ep.initDatabase(ctx, ep.config.initDBExecutable(), ep.config.runtimePath, ep.config.dataPath, ep.config.superuser(), ep.config.password, ep.config.locale, ep.config.encoding, ep.config.initDBArgs(), ep.configureCommand, ep.syncedLogger.file)
+	}
+
+	initDBWriter := ep.config.initDBLogger
+	if ep.config.logLevel != "" {
+		initDBWriter = newLevelWriter(initDBWriter, ep.config.logLevel)
+	}
+
+	initDBLogger, err := newSyncedLogger("", initDBWriter)
+	if err != nil {
+		return errors.New("unable to create initdb logger")
+	}
+
+	defer func() {
+		_ = initDBLogger.remove()
+	}()
+
+	initErr := ep.initDatabase(ctx, ep.config.initDBExecutable(), ep.config.runtimePath, ep.config.dataPath, ep.config.superuser(), ep.config.password, ep.config.locale, ep.config.encoding, ep.config.initDBArgs(), ep.configureCommand, initDBLogger.file)
+
+	if err := initDBLogger.flush(); err != nil && initErr == nil {
+		return err
+	}
+
+	return initErr
+}
+
+func (ep *EmbeddedPostgres) cleanDataDirectoryAndCloneTemplate() error {
+	if !dataDirIsValid(ep.config.templateDataPath, ep.config.version) {
+		return fmt.Errorf("template data directory %s does not contain a Postgres %s data directory", ep.config.templateDataPath, ep.config.version)
+	}
+
+	if err := os.RemoveAll(ep.config.dataPath); err != nil {
+		return fmt.Errorf("unable to clean up data directory %s with error: %s", ep.config.dataPath, err)
+	}
+
+	if err := copyDataDirectory(ep.config.templateDataPath, ep.config.dataPath); err != nil {
+		return fmt.Errorf("unable to copy template data directory %s to %s with error: %s", ep.config.templateDataPath, ep.config.dataPath, err)
+	}
+
+	return nil
+}
+
+// Stop will try to stop the Postgres process gracefully returning an error when there were any problems.
+func (ep *EmbeddedPostgres) Stop() error {
+	return ep.StopWithContext(context.Background())
+}
+
+// EnsureStopped stops the Postgres process if it is running, returning nil rather than ErrServerNotStarted when it
+// is not.
+func (ep *EmbeddedPostgres) EnsureStopped() error {
+	if !ep.started {
+		return nil
+	}
+
+	return ep.Stop()
+}
+
+// StopWithContext behaves as Stop but gives up waiting for Postgres to stop when the context is cancelled.
+func (ep *EmbeddedPostgres) StopWithContext(ctx context.Context) error {
+	if !ep.started {
+		return ErrServerNotStarted
+	}
+
+	if ep.processWatcher != nil {
+		ep.processWatcher.Stop()
+		ep.processWatcher = nil
+	}
+
+	var beforeStopErr error
+	if ep.config.beforeStop != nil && !ep.config.dryRun {
+		if beforeStopErr = runCallback(ep.config, ep.config.beforeStop); beforeStopErr != nil {
+			ep.config.logf("BeforeStop failed: %s", beforeStopErr)
+		}
+	}
+
+	ep.stopLogFollowing()
+
+	ep.enterPhase(PhaseStopping)
+
+	if err := stopPostgres(ctx, ep); err != nil {
+		return err
+	}
+
+	ep.started = false
+
+	if err := ep.syncedLogger.flush(); err != nil {
+		return err
+	}
+
+	ep.closeLogChannel()
+
+	if ep.config.removeDataOnStop && !ep.config.dryRun {
+		if err := ep.removeDataDirectory(); err != nil {
+			return err
+		}
+	}
+
+	ep.enterPhase(PhaseStopped)
+
+	if beforeStopErr != nil && ep.config.beforeStopFailsStop {
+		return fmt.Errorf("BeforeStop failed: %w", beforeStopErr)
+	}
+
+	return nil
+}
+
+// Port returns the port Postgres is listening on.
+// When the configured port is 0 this is the port assigned by the operating system once Start has been called.
+func (ep *EmbeddedPostgres) Port() uint32 {
+	return ep.config.port
+}
+
+// CacheLocation returns the path of the cached binaries archive for the configured version, operating system and
+// architecture, whether or not it has been downloaded yet.
+func (ep *EmbeddedPostgres) CacheLocation() string {
+	cacheLocation, _ := ep.cacheLocator()
+	return cacheLocation
+}
+
+// ResolvedBinaryURL returns the URL the binaries archive is downloaded from for the configured repository, version
+// and platform, e.g. to diagnose a mirror which does not serve it. Credentials in BinaryRepositoryURL are omitted.
+func (ep *EmbeddedPostgres) ResolvedBinaryURL() (string, error) {
+	if ep.configErr != nil {
+		return "", ep.configErr
+	}
+
+	if ep.config.fetchStrategy != nil {
+		return "", errors.New("binaries are fetched by a custom FetchStrategy, which has no known URL")
+	}
+
+	if err := ep.resolveVersion(context.Background()); err != nil {
+		return "", err
+	}
+
+	operatingSystem, architecture, version := ep.versionStrategy()
+
+	return binaryDownloadURL(moveRepositoryCredentialsFromURL(ep.config).binaryRepositoryURL, operatingSystem, architecture, version), nil
+}
+
+// BinariesPath returns the absolute path of the directory containing bin/pg_ctl, bin/psql etc.
+// When not configured it is derived from the cache location once Start has been called, until then it is empty.
+func (ep *EmbeddedPostgres) BinariesPath() string {
+	return absolutePath(ep.config.binariesPath)
+}
+
+// RuntimePath returns the absolute path of the runtime directory.
+// When not configured it is derived from the cache location once Start has been called, until then it is empty.
+func (ep *EmbeddedPostgres) RuntimePath() string {
+	return absolutePath(ep.config.runtimePath)
+}
+
+// DataPath returns the absolute path of the data directory.
+// When not configured it is derived from the runtime path once Start has been called, until then it is empty.
+func (ep *EmbeddedPostgres) DataPath() string {
+	return absolutePath(ep.config.dataPath)
+}
+
+// absolutePath returns path made absolute, leaving an empty or unresolvable path unchanged.
+func absolutePath(path string) string {
+	if path == "" {
+		return ""
+	}
+
+	if absolute, err := filepath.Abs(path); err == nil {
+		return absolute
+	}
+
+	return path
+}
+
+// PID returns the process ID of the running Postgres server, read from postmaster.pid in the data directory.
+func (ep *EmbeddedPostgres) PID() (int, error) {
+	if !ep.started {
+		return 0, ErrServerNotStarted
+	}
+
+	return readPostmasterPID(ep.config.dataPath)
+}
+
+func readPostmasterPID(dataPath string) (int, error) {
+	pidFile := filepath.Join(dataPath, "postmaster.pid")
+
+	content, err := os.ReadFile(pidFile)
+	if err != nil {
+		return 0, fmt.Errorf("unable to read %s: %w", pidFile, err)
+	}
+
+	firstLine := strings.SplitN(string(content), "\n", 2)[0]
+
+	pid, err := strconv.Atoi(strings.TrimSpace(firstLine))
+	if err != nil || pid <= 0 {
+		return 0, fmt.Errorf("malformed pid file %s", pidFile)
+	}
+
+	return pid, nil
+}
+
+// removeStalePostmasterPID removes a postmaster.pid left in the data directory by a Postgres which crashed or was
+// killed, which would otherwise make pg_ctl refuse to start. The file is only removed when the process it names is no
+// longer running and nothing listens on the port it records, so that a server which is still running is never started
+// a second time on the same data directory.
+func (ep *EmbeddedPostgres) removeStalePostmasterPID() error {
+	pidFile := filepath.Join(ep.config.dataPath, "postmaster.pid")
+
+	pid, err := readPostmasterPID(ep.config.dataPath)
+	if err != nil {
+		// a missing file is the usual case, a malformed one is left for Postgres to report
+		return nil
+	}
+
+	if processExists(pid) {
+		return nil
+	}
+
+	if port, ok := postmasterPort(pidFile); ok && ep.config.listenAddress() != "" {
+		if _, err := ensurePortAvailable(ep.config.listenAddress(), port); err != nil {
+			return nil
+		}
+	}
+
+	if err := os.Remove(pidFile); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("unable to remove stale %s: %w", pidFile, err)
+	}
+
+	ep.config.logf("removed stale %s left by process %d which is no longer running", pidFile, pid)
+
+	return nil
+}
+
+// postmasterPort returns the port recorded on the fourth line of postmaster.pid.
+func postmasterPort(pidFile string) (uint32, bool) {
+	content, err := os.ReadFile(pidFile)
+	if err != nil {
+		return 0, false
+	}
+
+	lines := strings.Split(string(content), "\n")
+	if len(lines) < 4 {
+		return 0, false
+	}
+
+	port, err := strconv.ParseUint(strings.TrimSpace(lines[3]), 10, 32)
+	if err != nil || port == 0 {
+		return 0, false
+	}
+
+	return uint32(port), true
+}
+
+// WaitUntilReady polls the configured database until it accepts queries, returning an error if it does not within
+// timeout. It does not require the server to have been started by Start, so it can be used with Postgres started by
+// other means.
+func (ep *EmbeddedPostgres) WaitUntilReady(timeout time.Duration) error {
+	config := ep.config
+	config.startTimeout = timeout
+
+	return healthCheckDatabaseOrTimeout(context.Background(), config)
+}
+
+// RecreateDatabase drops the configured database, terminating any connections to it, and creates it again empty
+// together with the configured Extensions. This is far faster than stopping and starting Postgres between tests.
+func (ep *EmbeddedPostgres) RecreateDatabase() error {
+	if !ep.started {
+		return ErrServerNotStarted
+	}
+
+	if ep.config.database == ep.config.maintenanceDatabase {
+		return fmt.Errorf("the %s maintenance database cannot be recreated", ep.config.maintenanceDatabase)
+	}
+
+	if err := dropDatabase(ep.config, ep.config.database); err != nil {
+		return err
+	}
+
+	if err := ep.createDatabase(ep.config.connectionHost(), ep.config.port, ep.config.superuser(), ep.config.password, ep.config.maintenanceDatabase, ep.config.database, ep.config.username); err != nil {
+		return err
+	}
+
+	if len(ep.config.extensions) > 0 {
+		return createExtensions(ep.config)
+	}
+
+	return nil
+}
+
+// ResetStats discards the statistics Postgres has gathered on the configured database with pg_stat_reset, and the
+// queries recorded by pg_stat_statements with pg_stat_statements_reset when that extension has been created and its
+// library loaded through shared_preload_libraries. This lets query performance tests measure each case on its own.
+func (ep *EmbeddedPostgres) ResetStats() error {
+	if !ep.started {
+		return ErrServerNotStarted
+	}
+
+	return resetStats(ep.config)
+}
+
+// Logs returns the output captured from initdb and Postgres so far, whether or not a Logger was configured.
+// It returns nil if Start has not been called or the output cannot be read.
+func (ep *EmbeddedPostgres) Logs() []byte {
+	if ep.syncedLogger == nil {
+		return nil
+	}
+
+	logContent, err := ep.syncedLogger.readLogs()
+	if err != nil {
+		return nil
+	}
+
+	return logContent
+}
+
+// TLSCertificate returns the PEM encoded certificate Postgres serves SSL connections with, generated at Start when
+// AutoTLS is set. Clients can trust it as a root certificate. It returns nil when TLS is not enabled.
+func (ep *EmbeddedPostgres) TLSCertificate() []byte {
+	return ep.tlsCertificate
+}
+
+// ConnectionURL returns a postgresql:// URL for the configured database, reflecting the port chosen at Start.
+func (ep *EmbeddedPostgres) ConnectionURL() string {
+	return ep.config.GetConnectionURL()
+}
+
+// ConnectionString returns a libpq key=value connection string for the configured database,
+// reflecting the port chosen at Start. It can be passed directly to sql.Open("postgres", ...).
+func (ep *EmbeddedPostgres) ConnectionString() string {
+	sslMode := "disable"
+	if ep.config.tlsEnabled() {
+		sslMode = "require"
+	}
+
+	return connectionString(ep.config.connectionHost(), ep.config.port, ep.config.username, ep.config.password, ep.config.database, sslMode)
+}
+
+func encodeOptions(port uint32, listenAddress string, parameters map[string]string) string {
+	options := []string{fmt.Sprintf("-p %d", port), fmt.Sprintf("-c listen_addresses=\"%s\"", listenAddress)}
+	for k, v := range parameters {
+		// Double-quote parameter values - they may have spaces.
+		// Careful: CMD on Windows uses only double quotes to delimit strings.
+		// It treats single quotes as regular characters.
+		options = append(options, fmt.Sprintf("-c %s=\"%s\"", k, v))
+	}
+	return strings.Join(options, " ")
+}
+
+func startCommand(ctx context.Context, config Config) *exec.Cmd {
+	return exec.CommandContext(ctx, filepath.Join(config.binariesPath, "bin/pg_ctl"), startArgs(config)...)
+}
+
+func startArgs(config Config) []string {
+	timeout := config.pgCtlStartTimeout
+	if timeout <= 0 {
+		timeout = config.startTimeout
+	}
+
+	args := []string{"start", "-w"}
+
+	if timeout > 0 {
+		args = append(args, "-t", strconv.Itoa(int(math.Ceil(timeout.Seconds()))))
+	}
+
+	return append(args,
+		"-D", config.dataPath,
+		"-o", encodeOptions(config.port, config.listenAddress(), config.serverParameters()))
+}
+
+func startPostgres(ctx context.Context, ep *EmbeddedPostgres) error {
+	postgresProcess := startCommand(ctx, ep.config)
+	postgresProcess.Stdout = ep.syncedLogger.file
+	postgresProcess.Stderr = ep.syncedLogger.stderrFile()
+	ep.configureCommand(postgresProcess)
+
+	if err := runServerCommand(postgresProcess, ep.config); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		_ = ep.syncedLogger.flush()
+		logContent, _ := ep.syncedLogger.readLogs()
+
+		return fmt.Errorf("could not start postgres using %s:\n%s", postgresProcess.String(), string(logContent))
+	}
+
+	return nil
+}
+
+func stopPostgres(ctx context.Context, ep *EmbeddedPostgres) error {
+	postgresBinary := filepath.Join(ep.config.binariesPath, "bin/pg_ctl")
+	postgresProcess := exec.CommandContext(ctx, postgresBinary, stopArgs(ep.config)...)
+	postgresProcess.Stderr = ep.syncedLogger.stderrFile()
+	postgresProcess.Stdout = ep.syncedLogger.file
+	ep.configureCommand(postgresProcess)
+
+	if ep.config.dryRun {
+		ep.config.logf("dry run: %s", postgresProcess)
+		return nil
+	}
+
+	if err := postgresProcess.Run(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// configureCommand applies the configured environment and platform specific options, such as RunAsUser, to a pg_ctl
+// or initdb invocation.
+func (ep *EmbeddedPostgres) configureCommand(cmd *exec.Cmd) {
+	cmd.Env = ep.config.processEnvironment()
+	applyPlatformSpecificOptions(cmd, ep.config)
+}
+
+func stopArgs(config Config) []string {
+	args := []string{"stop", "-w", "-D", config.dataPath}
+
+	if config.stopMode != "" {
+		args = append(args, "-m", config.stopMode)
+	}
+
+	if config.stopTimeout > 0 {
+		args = append(args, "-t", strconv.Itoa(int(math.Ceil(config.stopTimeout.Seconds()))))
+	}
+
+	return args
+}
+
+// ensurePortAvailable checks that nothing is listening on the given port, returning the port to be used.
+// When port is 0 a free port is assigned by the operating system and returned instead.
+//
+// The port is released again before Postgres binds to it, so another process may claim it in the meantime.
+// In that case Postgres fails to start and Start returns an error that can be retried.
+func ensurePortAvailable(address string, port uint32) (uint32, error) {
+	addresses := listenIPs(address)
+
+	for attempt := 1; ; attempt++ {
+		chosen, err := listenOnAll(addresses, port)
+		// a port chosen by the OS on one address may be taken on another, in which case another is chosen
+		if err == nil || port != 0 || attempt == randomPortAttempts {
+			return chosen, err
+		}
+	}
+}
+
+// randomPortAttempts is the number of ports chosen by the OS which are tried before giving up.
+const randomPortAttempts = 10
+
+// listenIPs resolves a listen_addresses value to the addresses Postgres listens on, so that a host name such as
+// localhost is checked on each of the IPv4 and IPv6 addresses it resolves to.
+func listenIPs(address string) []string {
+	var addresses []string
+
+	for _, host := range listenHosts(address) {
+		// "*" is how Postgres spells all interfaces, net.Listen expects an empty host instead
+		if host == "*" || host == "" {
+			addresses = append(addresses, "")
+			continue
+		}
+
+		if net.ParseIP(host) != nil {
+			addresses = append(addresses, host)
+			continue
+		}
+
+		resolved, err := net.LookupHost(host)
+		if err != nil || len(resolved) == 0 {
+			addresses = append(addresses, host)
+			continue
+		}
+
+		addresses = append(addresses, resolved...)
+	}
+
+	return addresses
+}
+
+// listenOnAll checks that port is free on each of addresses, returning the port chosen by the OS when port is 0.
+// Addresses which cannot be listened on at all, such as ::1 when IPv6 is disabled, are skipped as Postgres skips them.
+func listenOnAll(addresses []string, port uint32) (uint32, error) {
+	var listeners []net.Listener
+
+	defer func() {
+		for _, listener := range listeners {
+			_ = listener.Close()
+		}
+	}()
+
+	var unusable error
+
+	for _, address := range addresses {
+		listener, err := net.Listen("tcp", net.JoinHostPort(address, strconv.FormatUint(uint64(port), 10)))
+		if err != nil {
+			probe, probeErr := net.Listen("tcp", net.JoinHostPort(address, "0"))
+			if probeErr != nil {
+				unusable = probeErr
+				continue
+			}
+
+			_ = probe.Close()
+
+			return 0, fmt.Errorf("process already listening on port %d", port)
+		}
+
+		listeners = append(listeners, listener)
+
+		if tcpAddr, ok := listener.Addr().(*net.TCPAddr); ok && port == 0 {
+			port = uint32(tcpAddr.Port)
+		}
+	}
+
+	if len(listeners) == 0 {
+		return 0, fmt.Errorf("unable to listen on %s: %w", strings.Join(addresses, ", "), unusable)
+	}
+
+	return port, nil
+}
+
+// runtimeIsReusable reports whether runtimePath holds a complete extraction of the given version's binaries, as
+// opposed to nothing, another version or the remains of an extraction which was interrupted.
+func runtimeIsReusable(runtimePath string, version PostgresVersion) bool {
+	for _, binary := range []string{"pg_ctl", "postgres", "initdb"} {
+		if _, err := exec.LookPath(filepath.Join(runtimePath, "bin", binary)); err != nil {
+			return false
+		}
+	}
+
+	binariesVersion, err := readBinariesVersion(runtimePath)
+	if err != nil {
+		return false
+	}
+
+	return binariesMatchVersion(binariesVersion, version)
+}
+
+// binariesMatchVersion reports whether binaries reporting binariesVersion, e.g. 16.4, are of the configured version,
+// which may be a full version such as 16.4.0 or a partial one such as 16.
+func binariesMatchVersion(binariesVersion string, version PostgresVersion) bool {
+	binaries, configured := binariesVersion+".", string(version)+"."
+
+	return strings.HasPrefix(configured, binaries) || strings.HasPrefix(binaries, configured)
+}
+
+// readBinariesVersion returns the version reported by pg_ctl --version, e.g. "16.4".
+func readBinariesVersion(binariesPath string) (string, error) {
+	output, err := exec.Command(filepath.Join(binariesPath, "bin", "pg_ctl"), "--version").Output()
+	if err != nil {
+		return "", err
+	}
+
+	// the output is of the form "pg_ctl (PostgreSQL) 16.4", possibly followed by distribution details
+	fields := strings.Fields(string(output))
+	if len(fields) < 3 {
+		return "", fmt.Errorf("unexpected pg_ctl version output %q", output)
+	}
+
+	return fields[2], nil
+}
+
+// checkTimeZone rejects a time zone which Postgres would fail to start with, as it is neither a POSIX specification nor
+// one of the time zones bundled in binariesPath. Names are matched case-insensitively as Postgres does. Binaries built
+// against the system time zone database bundle none, in which case Postgres is left to check the name itself.
+func checkTimeZone(binariesPath, timeZone string) error {
+	if timeZone == "" || posixTimeZonePattern.MatchString(timeZone) {
+		return nil
+	}
+
+	for _, dir := range []string{filepath.Join(binariesPath, "share", "postgresql", "timezone"), filepath.Join(binariesPath, "share", "timezone")} {
+		if _, err := os.Stat(dir); err != nil {
+			continue
+		}
+
+		unknown := fmt.Errorf("unknown time zone %q, it is not one of the time zones bundled in %s", timeZone, dir)
+
+		path := dir
+		for _, part := range strings.Split(timeZone, "/") {
+			entries, err := os.ReadDir(path)
+			if err != nil {
+				return unknown
+			}
+
+			next := ""
+			for _, entry := range entries {
+				if strings.EqualFold(entry.Name(), part) {
+					next = filepath.Join(path, entry.Name())
+					break
+				}
+			}
+
+			if next == "" {
+				return unknown
+			}
+
+			path = next
+		}
+
+		if info, err := os.Stat(path); err != nil || info.IsDir() {
+			return unknown
+		}
+
+		return nil
+	}
+
+	return nil
+}
+
+// isWithinDir reports whether path is dir or is contained by it.
+func isWithinDir(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	if err != nil {
+		return false
+	}
+
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+func dataDirIsValid(dataDir string, version PostgresVersion) bool {
+	pgVersion := filepath.Join(dataDir, "PG_VERSION")
+
+	d, err := os.ReadFile(pgVersion)
+	if err != nil {
+		return false
+	}
+
+	dataMajor, ok := majorVersion(strings.TrimSpace(string(d)))
+	if !ok {
+		return false
+	}
+
+	versionMajor, ok := majorVersion(string(version))
+
+	return ok && dataMajor == versionMajor
+}
+
+// majorVersion returns the numeric major version of a version such as 16.4.0 or of the contents of a PG_VERSION file
+// such as 16. Before Postgres 10 the major version also includes the minor number, so 9.6.24 and 9.6 both return
+// [9, 6] while a bare 9 is not a major version.
+func majorVersion(version string) ([2]int, bool) {
+	var major [2]int
+
+	parts := strings.Split(version, ".")
+	for i := 0; i < len(parts) && i < len(major); i++ {
+		n, err := strconv.Atoi(parts[i])
+		if err != nil || n < 0 {
+			return major, false
+		}
+
+		major[i] = n
+	}
+
+	if major[0] >= 10 {
+		return [2]int{major[0]}, true
+	}
 
-	return strings.HasPrefix(string(version), v)
+	return major, len(parts) >= 2
 }
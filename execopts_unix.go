@@ -15,4 +15,17 @@ func applyPlatformSpecificOptions(cmd *exec.Cmd, config Config) {
 		}
 		cmd.SysProcAttr.Setpgid = true
 	}
+
+	// checked by Start, an unknown user is reported there
+	if credential, err := runAsCredential(config); err == nil && credential != nil {
+		if cmd.SysProcAttr == nil {
+			cmd.SysProcAttr = &syscall.SysProcAttr{}
+		}
+		cmd.SysProcAttr.Credential = credential
+	}
+}
+
+// runServerCommand runs the pg_ctl command which starts the server.
+func runServerCommand(cmd *exec.Cmd, _ Config) error {
+	return cmd.Run()
 }
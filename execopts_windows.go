@@ -4,10 +4,47 @@
 package embeddedpostgres
 
 import (
+	"fmt"
 	"os/exec"
+	"sync"
 	"syscall"
+	"unsafe"
 )
 
+const (
+	jobObjectExtendedLimitInformationClass = 9
+	jobObjectLimitKillOnJobClose           = 0x2000
+	createSuspended                        = 0x4
+	processSetQuota                        = 0x0100
+	threadSuspendResume                    = 0x0002
+)
+
+//nolint:gochecknoglobals
+var (
+	procCreateJobObjectW         = kernel32.NewProc("CreateJobObjectW")
+	procSetInformationJobObject  = kernel32.NewProc("SetInformationJobObject")
+	procAssignProcessToJobObject = kernel32.NewProc("AssignProcessToJobObject")
+	procThread32First            = kernel32.NewProc("Thread32First")
+	procThread32Next             = kernel32.NewProc("Thread32Next")
+	procOpenThread               = kernel32.NewProc("OpenThread")
+	procResumeThread             = kernel32.NewProc("ResumeThread")
+
+	killOnCloseJobOnce sync.Once
+	killOnCloseJob     uintptr
+	killOnCloseJobErr  error
+)
+
+// threadEntry32 is THREADENTRY32, as filled in by Thread32First and Thread32Next.
+type threadEntry32 struct {
+	size           uint32
+	usage          uint32
+	threadID       uint32
+	ownerProcessID uint32
+	basePriority   int32
+	deltaPriority  int32
+	flags          uint32
+}
+
 func applyPlatformSpecificOptions(cmd *exec.Cmd, config Config) {
 	if config.ownProcessGroup {
 		if cmd.SysProcAttr == nil {
@@ -16,3 +53,132 @@ func applyPlatformSpecificOptions(cmd *exec.Cmd, config Config) {
 		cmd.SysProcAttr.CreationFlags = syscall.CREATE_NEW_PROCESS_GROUP
 	}
 }
+
+// runServerCommand runs the pg_ctl command which starts the server. With OwnProcessGroup pg_ctl is created suspended
+// and assigned to a job object which terminates every process in it when this process exits, even if it is killed
+// before Stop is called, and only then resumed. The postmaster started by pg_ctl inherits the job, whereas the
+// current process and its other children are left out of it.
+func runServerCommand(cmd *exec.Cmd, config Config) error {
+	if !config.ownProcessGroup {
+		return cmd.Run()
+	}
+
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.CreationFlags |= createSuspended
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	if err := assignToKillOnCloseJob(cmd.Process.Pid); err != nil {
+		config.logf("unable to ensure postgres is terminated with this process: %s", err)
+	}
+
+	if err := resumeProcess(cmd.Process.Pid); err != nil {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+
+		return fmt.Errorf("unable to resume %s with error: %s", cmd, err)
+	}
+
+	return cmd.Wait()
+}
+
+// assignToKillOnCloseJob assigns the process with the given ID to the job object shared by the servers started by
+// this process, creating it on first use.
+func assignToKillOnCloseJob(pid int) error {
+	killOnCloseJobOnce.Do(func() {
+		killOnCloseJob, killOnCloseJobErr = newKillOnCloseJob()
+	})
+
+	if killOnCloseJobErr != nil {
+		return killOnCloseJobErr
+	}
+
+	process, err := syscall.OpenProcess(processSetQuota|syscall.PROCESS_TERMINATE, false, uint32(pid))
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		_ = syscall.CloseHandle(process)
+	}()
+
+	if r1, _, err := procAssignProcessToJobObject.Call(killOnCloseJob, uintptr(process)); r1 == 0 {
+		return err
+	}
+
+	return nil
+}
+
+// newKillOnCloseJob creates a job object which terminates every process in it when its last handle is closed. The
+// handle is deliberately never closed, it is closed by Windows when the current process exits.
+func newKillOnCloseJob() (uintptr, error) {
+	job, _, err := procCreateJobObjectW.Call(0, 0)
+	if job == 0 {
+		return 0, err
+	}
+
+	// JOBOBJECT_EXTENDED_LIMIT_INFORMATION is 112 bytes on 32-bit and 144 bytes on 64-bit Windows, in both
+	// LimitFlags follows the two 64-bit time limits
+	var info [144]byte
+	size := uintptr(112)
+	if unsafe.Sizeof(uintptr(0)) == 8 {
+		size = 144
+	}
+
+	*(*uint32)(unsafe.Pointer(&info[16])) = jobObjectLimitKillOnJobClose
+
+	if r1, _, err := procSetInformationJobObject.Call(job, jobObjectExtendedLimitInformationClass, uintptr(unsafe.Pointer(&info[0])), size); r1 == 0 {
+		_ = syscall.CloseHandle(syscall.Handle(job))
+		return 0, err
+	}
+
+	return job, nil
+}
+
+// resumeProcess resumes the threads of a process created suspended. exec.Cmd does not expose the handle of its main
+// thread, so the threads are found through a snapshot.
+func resumeProcess(pid int) error {
+	snapshot, err := syscall.CreateToolhelp32Snapshot(syscall.TH32CS_SNAPTHREAD, 0)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		_ = syscall.CloseHandle(snapshot)
+	}()
+
+	entry := threadEntry32{}
+	entry.size = uint32(unsafe.Sizeof(entry))
+	resumed := false
+
+	for r1, _, _ := procThread32First.Call(uintptr(snapshot), uintptr(unsafe.Pointer(&entry))); r1 != 0; r1, _, _ = procThread32Next.Call(uintptr(snapshot), uintptr(unsafe.Pointer(&entry))) {
+		if entry.ownerProcessID != uint32(pid) {
+			continue
+		}
+
+		thread, _, err := procOpenThread.Call(threadSuspendResume, 0, uintptr(entry.threadID))
+		if thread == 0 {
+			return err
+		}
+
+		// ResumeThread returns (DWORD)-1 on failure
+		suspendCount, _, err := procResumeThread.Call(thread)
+		_ = syscall.CloseHandle(syscall.Handle(thread))
+
+		if uint32(suspendCount) == 0xFFFFFFFF {
+			return err
+		}
+
+		resumed = true
+	}
+
+	if !resumed {
+		return fmt.Errorf("no threads of process %d were found", pid)
+	}
+
+	return nil
+}
@@ -0,0 +1,66 @@
+//go:build windows
+// +build windows
+
+package embeddedpostgres
+
+import (
+	"os/exec"
+	"syscall"
+	"testing"
+	"unsafe"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_applyPlatformSpecificOptions_OwnProcessGroup(t *testing.T) {
+	cmd := exec.Command("cmd", "/c", "exit")
+	applyPlatformSpecificOptions(cmd, DefaultConfig().OwnProcessGroup(true))
+
+	require.NotNil(t, cmd.SysProcAttr)
+	assert.Equal(t, uint32(syscall.CREATE_NEW_PROCESS_GROUP), cmd.SysProcAttr.CreationFlags)
+}
+
+func Test_runServerCommand_ResumesSuspendedProcess(t *testing.T) {
+	cmd := exec.Command("cmd", "/c", "exit", "3")
+	applyPlatformSpecificOptions(cmd, DefaultConfig().OwnProcessGroup(true))
+
+	err := runServerCommand(cmd, DefaultConfig().OwnProcessGroup(true))
+
+	var exitErr *exec.ExitError
+	require.ErrorAs(t, err, &exitErr)
+	assert.Equal(t, 3, exitErr.ExitCode())
+}
+
+func Test_assignToKillOnCloseJob_OnlyAssignsChild(t *testing.T) {
+	cmd := exec.Command("ping", "-n", "30", "127.0.0.1")
+	require.NoError(t, cmd.Start())
+
+	defer func() {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+	}()
+
+	require.NoError(t, assignToKillOnCloseJob(cmd.Process.Pid))
+
+	child, err := syscall.OpenProcess(syscall.PROCESS_QUERY_INFORMATION, false, uint32(cmd.Process.Pid))
+	require.NoError(t, err)
+
+	defer func() {
+		_ = syscall.CloseHandle(child)
+	}()
+
+	current, err := syscall.GetCurrentProcess()
+	require.NoError(t, err)
+
+	assert.True(t, isProcessInJob(t, child, killOnCloseJob))
+	assert.False(t, isProcessInJob(t, current, killOnCloseJob))
+}
+
+func isProcessInJob(t *testing.T, process syscall.Handle, job uintptr) bool {
+	var inJob int32
+	r1, _, err := kernel32.NewProc("IsProcessInJob").Call(uintptr(process), job, uintptr(unsafe.Pointer(&inJob)))
+	require.NotZero(t, r1, err)
+
+	return inJob != 0
+}
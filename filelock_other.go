@@ -0,0 +1,11 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !windows
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!windows
+
+package embeddedpostgres
+
+import "context"
+
+// lockFile is a no-op on platforms without file locking, downloads are then only serialised within a process.
+func lockFile(ctx context.Context, path string) (func(), error) {
+	return func() {}, nil
+}
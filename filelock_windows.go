@@ -0,0 +1,57 @@
+//go:build windows
+// +build windows
+
+package embeddedpostgres
+
+import (
+	"context"
+	"os"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+const (
+	lockfileFailImmediately = 0x1
+	lockfileExclusiveLock   = 0x2
+	errorLockViolation      = syscall.Errno(33)
+)
+
+//nolint:gochecknoglobals
+var (
+	kernel32         = syscall.NewLazyDLL("kernel32.dll")
+	procLockFileEx   = kernel32.NewProc("LockFileEx")
+	procUnlockFileEx = kernel32.NewProc("UnlockFileEx")
+)
+
+// lockFile takes an exclusive lock on the file at path, shared with other processes, waiting until it is available
+// or ctx is done. The returned function releases the lock.
+func lockFile(ctx context.Context, path string) (func(), error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		overlapped := new(syscall.Overlapped)
+		r1, _, err := procLockFileEx.Call(file.Fd(), lockfileExclusiveLock|lockfileFailImmediately, 0, 1, 0, uintptr(unsafe.Pointer(overlapped)))
+		if r1 != 0 {
+			return func() {
+				_, _, _ = procUnlockFileEx.Call(file.Fd(), 0, 1, 0, uintptr(unsafe.Pointer(new(syscall.Overlapped))))
+				_ = file.Close()
+			}, nil
+		}
+
+		if err != errorLockViolation {
+			_ = file.Close()
+			return nil, err
+		}
+
+		select {
+		case <-ctx.Done():
+			_ = file.Close()
+			return nil, ctx.Err()
+		case <-time.After(fileLockPollInterval):
+		}
+	}
+}
@@ -3,6 +3,7 @@ module github.com/fergusstrange/embedded-postgres
 go 1.18
 
 require (
+	github.com/klauspost/compress v1.17.2
 	github.com/lib/pq v1.10.9
 	github.com/stretchr/testify v1.10.0
 	github.com/xi2/xz v0.0.0-20171230120015-48954b6210f8
@@ -1,17 +1,25 @@
 package embeddedpostgres
 
 import (
+	"bytes"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
 	"time"
 )
 
 type syncedLogger struct {
+	mu     sync.Mutex
 	offset int64
 	logger io.Writer
 	file   *os.File
+	// stderr holds stderr separately from file when StderrLogger is set.
+	stderr *syncedLogger
 }
 
 func newSyncedLogger(dir string, logger io.Writer) (*syncedLogger, error) {
@@ -28,7 +36,73 @@ func newSyncedLogger(dir string, logger io.Writer) (*syncedLogger, error) {
 	return &s, nil
 }
 
+// separateStderr captures stderr in a file of its own forwarded to logger, rather than together with stdout.
+func (s *syncedLogger) separateStderr(logger io.Writer) error {
+	stderr, err := newSyncedLogger(filepath.Dir(s.file.Name()), logger)
+	if err != nil {
+		return err
+	}
+
+	s.stderr = stderr
+
+	return nil
+}
+
+// stderrFile returns the file stderr of commands is written to.
+func (s *syncedLogger) stderrFile() *os.File {
+	if s.stderr != nil {
+		return s.stderr.file
+	}
+
+	return s.file
+}
+
+// readLogs returns the logs captured so far, stdout followed by stderr when they are held separately.
+func (s *syncedLogger) readLogs() ([]byte, error) {
+	logContent, err := readLogsOrTimeout(s.file)
+	if err != nil || s.stderr == nil {
+		return logContent, err
+	}
+
+	stderrContent, err := readLogsOrTimeout(s.stderr.file)
+	if err != nil {
+		return stderrContent, err
+	}
+
+	return append(logContent, stderrContent...), nil
+}
+
+// remove closes and deletes the file holding the logs.
+func (s *syncedLogger) remove() error {
+	if s.stderr != nil {
+		if err := s.stderr.remove(); err != nil {
+			return err
+		}
+	}
+
+	if err := s.file.Close(); err != nil {
+		return err
+	}
+
+	return os.Remove(s.file.Name())
+}
+
 func (s *syncedLogger) flush() error {
+	if err := s.flushFile(); err != nil {
+		return err
+	}
+
+	if s.stderr != nil {
+		return s.stderr.flush()
+	}
+
+	return nil
+}
+
+func (s *syncedLogger) flushFile() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
 	if s.logger != nil {
 		file, err := os.Open(s.file.Name())
 		if err != nil {
@@ -56,6 +130,241 @@ func (s *syncedLogger) flush() error {
 	return nil
 }
 
+// lineWriter is an io.Writer which forwards each complete line written to it, without its line ending, to logLine.
+// Partial lines are held back until the rest of the line is written.
+type lineWriter struct {
+	logLine func(line string)
+	partial []byte
+}
+
+func newLineWriter(logLine func(line string)) *lineWriter {
+	return &lineWriter{logLine: logLine}
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.partial = append(w.partial, p...)
+
+	for {
+		i := bytes.IndexByte(w.partial, '\n')
+		if i < 0 {
+			break
+		}
+
+		w.logLine(string(bytes.TrimRight(w.partial[:i], "\r")))
+		w.partial = w.partial[i+1:]
+	}
+
+	return len(p), nil
+}
+
+// logLevels are the severities accepted by LogLevel, from least to most severe.
+//
+//nolint:gochecknoglobals
+var logLevels = []string{"DEBUG", "INFO", "LOG", "NOTICE", "WARNING", "ERROR", "FATAL", "PANIC"}
+
+// logLevelRank returns the position of level in logLevels, or -1 if it is not a known severity. DEBUG1 to DEBUG5 rank
+// as DEBUG.
+func logLevelRank(level string) int {
+	level = strings.ToUpper(level)
+	if strings.HasPrefix(level, "DEBUG") && len(level) == len("DEBUG1") && level[5] >= '1' && level[5] <= '5' {
+		level = "DEBUG"
+	}
+
+	for i, known := range logLevels {
+		if level == known {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// severityPattern matches the severity of a Postgres log line, e.g. "2024-05-01 10:00:00.000 UTC [42] LOG:  ...", or
+// of a message from a client tool, e.g. "initdb: warning: ...".
+//
+//nolint:gochecknoglobals
+var severityPattern = regexp.MustCompile(`(?:^|\s)([A-Z]+[1-5]?):  |^[\w.-]+: (error|warning|detail|hint): `)
+
+// levelWriter is an io.Writer which forwards the complete lines written to it to next when their severity is at or
+// above threshold. Lines continuing a message, such as DETAIL or indented lines, take the severity of the message and
+// output without a severity, such as initdb's progress, counts as LOG.
+type levelWriter struct {
+	next      io.Writer
+	threshold int
+	previous  int
+	lines     *lineWriter
+	err       error
+}
+
+func newLevelWriter(next io.Writer, level string) *levelWriter {
+	w := &levelWriter{next: next, threshold: logLevelRank(level), previous: logLevelRank("LOG")}
+	w.lines = newLineWriter(w.forward)
+
+	return w
+}
+
+func (w *levelWriter) Write(p []byte) (int, error) {
+	if _, err := w.lines.Write(p); err != nil {
+		return 0, err
+	}
+
+	err := w.err
+	w.err = nil
+
+	return len(p), err
+}
+
+func (w *levelWriter) forward(line string) {
+	w.previous = w.severity(line)
+	if w.previous < w.threshold || w.err != nil {
+		return
+	}
+
+	_, w.err = io.WriteString(w.next, line+"\n")
+}
+
+func (w *levelWriter) severity(line string) int {
+	if strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t") {
+		return w.previous
+	}
+
+	match := severityPattern.FindStringSubmatch(line)
+	if match == nil {
+		return logLevelRank("LOG")
+	}
+
+	if rank := logLevelRank(match[1] + match[2]); rank >= 0 {
+		return rank
+	}
+
+	// DETAIL, HINT, CONTEXT, STATEMENT and the like belong to the preceding message
+	return w.previous
+}
+
+// writeLine writes a line to the logger directly, without it going through the file holding the Postgres logs.
+func (s *syncedLogger) writeLine(line string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.logger != nil {
+		_, _ = io.WriteString(s.logger, line+"\n")
+	}
+}
+
+// libraryLog is shared by the copies of a Config held by an EmbeddedPostgres and its fetch strategies, so that the
+// messages of the library itself reach the syncedLogger of the current Start wherever they are reported from.
+type libraryLog struct {
+	mu     sync.Mutex
+	logger *syncedLogger
+}
+
+func (l *libraryLog) setLogger(logger *syncedLogger) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.logger = logger
+}
+
+// writeLine writes a line to the syncedLogger of the current Start, returning false when there is none.
+func (l *libraryLog) writeLine(line string) bool {
+	if l == nil {
+		return false
+	}
+
+	l.mu.Lock()
+	logger := l.logger
+	l.mu.Unlock()
+
+	if logger == nil {
+		return false
+	}
+
+	logger.writeLine(line)
+
+	return true
+}
+
+// logFollowInterval is how often the Postgres log is forwarded while following it.
+const logFollowInterval = 100 * time.Millisecond
+
+// follow flushes the logger every logFollowInterval, so that output reaches it while Postgres is running rather than
+// only when it starts and stops. Following ends when the returned function is called.
+func (s *syncedLogger) follow() func() {
+	stop := make(chan struct{})
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		ticker := time.NewTicker(logFollowInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				_ = s.flush()
+			}
+		}
+	}()
+
+	return func() {
+		close(stop)
+		<-done
+	}
+}
+
+// channelWriter is an io.Writer which sends each complete line written to it to a channel. When the channel is full
+// the line is dropped, or with blocking the write waits for room. Lines written once it is closed are discarded.
+type channelWriter struct {
+	mu       sync.Mutex
+	ch       chan<- string
+	blocking bool
+	closed   bool
+	lines    *lineWriter
+}
+
+func newChannelWriter(ch chan<- string, blocking bool) *channelWriter {
+	w := &channelWriter{ch: ch, blocking: blocking}
+	w.lines = newLineWriter(w.send)
+
+	return w
+}
+
+func (w *channelWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.lines.Write(p)
+}
+
+func (w *channelWriter) send(line string) {
+	if w.closed {
+		return
+	}
+
+	if w.blocking {
+		w.ch <- line
+		return
+	}
+
+	select {
+	case w.ch <- line:
+	default:
+	}
+}
+
+func (w *channelWriter) close() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if !w.closed {
+		w.closed = true
+		close(w.ch)
+	}
+}
+
 func readLogsOrTimeout(logger *os.File) (logContent []byte, err error) {
 	logContent = []byte("logs could not be read")
 
@@ -1,10 +1,13 @@
 package embeddedpostgres
 
 import (
+	"bytes"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -60,6 +63,47 @@ func Test_SyncedLogger_NoErrorDuringFlush(t *testing.T) {
 	assert.Equal(t, "some logs\non a new line", string(logger.logLines))
 }
 
+func Test_SyncedLogger_SeparateStderr(t *testing.T) {
+	logger := customLogger{}
+	stderrLogger := customLogger{}
+
+	sl, err := newSyncedLogger("", &logger)
+	require.NoError(t, err)
+	require.NoError(t, sl.separateStderr(&stderrLogger))
+
+	defer func() {
+		require.NoError(t, sl.remove())
+		assert.NoFileExists(t, sl.stderrFile().Name())
+	}()
+
+	assert.NotEqual(t, sl.file, sl.stderrFile())
+
+	_, err = sl.file.WriteString("waiting for server to start....\n")
+	require.NoError(t, err)
+	_, err = sl.stderrFile().WriteString("FATAL:  could not create lock file\n")
+	require.NoError(t, err)
+
+	require.NoError(t, sl.flush())
+
+	assert.Equal(t, "waiting for server to start....\n", string(logger.logLines))
+	assert.Equal(t, "FATAL:  could not create lock file\n", string(stderrLogger.logLines))
+
+	logContent, err := sl.readLogs()
+	require.NoError(t, err)
+	assert.Equal(t, "waiting for server to start....\nFATAL:  could not create lock file\n", string(logContent))
+}
+
+func Test_SyncedLogger_MergedStderr(t *testing.T) {
+	sl, err := newSyncedLogger("", &customLogger{})
+	require.NoError(t, err)
+
+	defer func() {
+		require.NoError(t, sl.remove())
+	}()
+
+	assert.Equal(t, sl.file, sl.stderrFile())
+}
+
 func Test_readLogsOrTimeout(t *testing.T) {
 	logFile, err := ioutil.TempFile("", "prepare_database_test_log")
 	if err != nil {
@@ -81,3 +125,106 @@ func Test_readLogsOrTimeout(t *testing.T) {
 	assert.Equal(t, []byte("logs could not be read"), logContent)
 	assert.EqualError(t, err, fmt.Sprintf("open %s: no such file or directory", logFile.Name()))
 }
+
+func Test_lineWriter_SplitsLines(t *testing.T) {
+	var lines []string
+	w := newLineWriter(func(line string) {
+		lines = append(lines, line)
+	})
+
+	_, _ = w.Write([]byte("one\r\ntw"))
+	_, _ = w.Write([]byte("o\nthree"))
+
+	assert.Equal(t, []string{"one", "two"}, lines)
+}
+
+func Test_channelWriter_DropsWhenFull(t *testing.T) {
+	ch := make(chan string, 1)
+	w := newChannelWriter(ch, false)
+
+	_, err := w.Write([]byte("first\nsecond\n"))
+	assert.NoError(t, err)
+
+	w.close()
+	_, err = w.Write([]byte("after close\n"))
+	assert.NoError(t, err)
+
+	var lines []string
+	for line := range ch {
+		lines = append(lines, line)
+	}
+
+	assert.Equal(t, []string{"first"}, lines)
+}
+
+func Test_channelWriter_Blocking(t *testing.T) {
+	ch := make(chan string)
+	w := newChannelWriter(ch, true)
+
+	go func() {
+		_, _ = w.Write([]byte("first\nsecond\n"))
+		w.close()
+	}()
+
+	var lines []string
+	for line := range ch {
+		lines = append(lines, line)
+	}
+
+	assert.Equal(t, []string{"first", "second"}, lines)
+}
+
+func Test_SyncedLogger_Follow(t *testing.T) {
+	logger := customLogger{}
+
+	s, err := newSyncedLogger(t.TempDir(), &logger)
+	require.NoError(t, err)
+
+	stop := s.follow()
+	defer stop()
+
+	_, err = s.file.Write([]byte("written while running\n"))
+	require.NoError(t, err)
+
+	assert.Eventually(t, func() bool {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		return string(logger.logLines) == "written while running\n"
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func Test_logLevelRank(t *testing.T) {
+	assert.Equal(t, 0, logLevelRank("DEBUG3"))
+	assert.Equal(t, 2, logLevelRank("log"))
+	assert.Equal(t, 4, logLevelRank("WARNING"))
+	assert.Equal(t, -1, logLevelRank("DEBUG6"))
+	assert.Equal(t, -1, logLevelRank("DETAIL"))
+}
+
+func Test_levelWriter(t *testing.T) {
+	var output bytes.Buffer
+	writer := newLevelWriter(&output, "WARNING")
+
+	_, err := writer.Write([]byte(strings.Join([]string{
+		"The files belonging to this database system will be owned by user \"postgres\".",
+		"initdb: warning: enabling \"trust\" authentication for local connections",
+		"2024-05-01 10:00:00.000 UTC [42] LOG:  starting PostgreSQL 16.4",
+		"2024-05-01 10:00:01.000 UTC [43] ERROR:  relation \"wines\" does not exist at character 15",
+		"2024-05-01 10:00:01.000 UTC [43] STATEMENT:  SELECT * FROM wines",
+		"2024-05-01 10:00:02.000 UTC [44] NOTICE:  table \"beers\" does not exist, skipping",
+		"2024-05-01 10:00:02.000 UTC [44] DETAIL:  skipped as it is only a notice",
+		"2024-05-01 10:00:03.000 UTC [42] LOG:  database system is shut",
+	}, "\n")))
+	require.NoError(t, err)
+
+	// the last line is incomplete and held back
+	_, err = writer.Write([]byte(" down\n"))
+	require.NoError(t, err)
+
+	assert.Equal(t, strings.Join([]string{
+		"initdb: warning: enabling \"trust\" authentication for local connections",
+		"2024-05-01 10:00:01.000 UTC [43] ERROR:  relation \"wines\" does not exist at character 15",
+		"2024-05-01 10:00:01.000 UTC [43] STATEMENT:  SELECT * FROM wines",
+	}, "\n")+"\n", output.String())
+}
@@ -7,6 +7,7 @@ go 1.18
 require github.com/fergusstrange/embedded-postgres v0.0.0
 
 require (
+	github.com/klauspost/compress v1.17.2 // indirect
 	github.com/lib/pq v1.10.9 // indirect
 	github.com/xi2/xz v0.0.0-20171230120015-48954b6210f8 // indirect
 )
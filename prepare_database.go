@@ -6,9 +6,12 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/lib/pq"
 )
@@ -18,36 +21,30 @@ const (
 	fmtAfterError  = "%v happened after error: %w"
 )
 
-type initDatabase func(binaryExtractLocation, runtimePath, pgDataDir, username, password, locale string, encoding string, logger *os.File) error
-type createDatabase func(port uint32, username, password, database string) error
+type initDatabase func(ctx context.Context, initDBPath, runtimePath, pgDataDir, username, password, locale string, encoding string, parameters []string, configure func(cmd *exec.Cmd), logger *os.File) error
+type createDatabase func(host string, port uint32, username, password, maintenanceDatabase, database, owner string) error
 
-func defaultInitDatabase(binaryExtractLocation, runtimePath, pgDataDir, username, password, locale string, encoding string, logger *os.File) error {
+func defaultInitDatabase(ctx context.Context, initDBPath, runtimePath, pgDataDir, username, password, locale string, encoding string, parameters []string, configure func(cmd *exec.Cmd), logger *os.File) error {
 	passwordFile, err := createPasswordFile(runtimePath, password)
 	if err != nil {
 		return err
 	}
 
-	args := []string{
-		"-A", "password",
-		"-U", username,
-		"-D", pgDataDir,
-		fmt.Sprintf("--pwfile=%s", passwordFile),
-	}
-
-	if locale != "" {
-		args = append(args, fmt.Sprintf("--locale=%s", locale))
-	}
-
-	if encoding != "" {
-		args = append(args, fmt.Sprintf("--encoding=%s", encoding))
+	postgresInitDBProcess := initDBCommand(ctx, initDBPath, pgDataDir, username, passwordFile, locale, encoding, parameters)
+	if configure != nil {
+		configure(postgresInitDBProcess)
 	}
-
-	postgresInitDBBinary := filepath.Join(binaryExtractLocation, "bin/initdb")
-	postgresInitDBProcess := exec.Command(postgresInitDBBinary, args...)
 	postgresInitDBProcess.Stderr = logger
 	postgresInitDBProcess.Stdout = logger
 
 	if err = postgresInitDBProcess.Run(); err != nil {
+		// the password must not be left on disk
+		_ = os.Remove(passwordFile)
+
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
 		logContent, readLogsErr := readLogsOrTimeout(logger) // we want to preserve the original error
 		if readLogsErr != nil {
 			logContent = []byte(string(logContent) + " - " + readLogsErr.Error())
@@ -62,21 +59,53 @@ func defaultInitDatabase(binaryExtractLocation, runtimePath, pgDataDir, username
 	return nil
 }
 
+// initDBCommand returns the invocation of the initdb executable at initDBPath creating pgDataDir with a bootstrap
+// superuser whose password is read from passwordFile.
+func initDBCommand(ctx context.Context, initDBPath, pgDataDir, username, passwordFile, locale, encoding string, parameters []string) *exec.Cmd {
+	args := []string{
+		"-A", "password",
+		"-U", username,
+		"-D", pgDataDir,
+		fmt.Sprintf("--pwfile=%s", passwordFile),
+	}
+
+	if locale != "" {
+		args = append(args, fmt.Sprintf("--locale=%s", locale))
+	}
+
+	if encoding != "" {
+		args = append(args, fmt.Sprintf("--encoding=%s", encoding))
+	}
+
+	args = append(args, parameters...)
+
+	return exec.CommandContext(ctx, initDBPath, args...)
+}
+
 func createPasswordFile(runtimePath, password string) (string, error) {
-	passwordFileLocation := filepath.Join(runtimePath, "pwfile")
+	passwordFileLocation := passwordFilePath(runtimePath)
 	if err := os.WriteFile(passwordFileLocation, []byte(password), 0600); err != nil {
 		return "", fmt.Errorf("unable to write password file to %s", passwordFileLocation)
 	}
 
+	if err := matchDirectoryOwner(passwordFileLocation); err != nil {
+		return "", fmt.Errorf("unable to change owner of password file %s: %w", passwordFileLocation, err)
+	}
+
 	return passwordFileLocation, nil
 }
 
-func defaultCreateDatabase(port uint32, username, password, database string) (err error) {
-	if database == "postgres" {
+func passwordFilePath(runtimePath string) string {
+	return filepath.Join(runtimePath, "pwfile")
+}
+
+func defaultCreateDatabase(host string, port uint32, username, password, maintenanceDatabase, database, owner string) (err error) {
+	statement := createDatabaseStatement(username, maintenanceDatabase, database, owner)
+	if statement == "" {
 		return nil
 	}
 
-	conn, err := openDatabaseConnection(port, username, password, "postgres")
+	conn, err := openDatabaseConnection(host, port, username, password, maintenanceDatabase)
 	if err != nil {
 		return errorCustomDatabase(database, err)
 	}
@@ -86,13 +115,251 @@ func defaultCreateDatabase(port uint32, username, password, database string) (er
 		err = connectionClose(db, err)
 	}()
 
-	if _, err := db.Exec(fmt.Sprintf("CREATE DATABASE \"%s\"", database)); err != nil {
+	if _, err := db.Exec(statement); err != nil {
 		return errorCustomDatabase(database, err)
 	}
 
 	return nil
 }
 
+// createDatabaseStatement returns the statement creating database, or an empty string when it is the maintenance
+// database, which is connected to and so always exists.
+func createDatabaseStatement(username, maintenanceDatabase, database, owner string) string {
+	if database == maintenanceDatabase {
+		return ""
+	}
+
+	statement := "CREATE DATABASE " + pq.QuoteIdentifier(database)
+	if owner != "" && owner != username {
+		statement += " OWNER " + pq.QuoteIdentifier(owner)
+	}
+
+	return statement
+}
+
+// createRole creates the configured user as a login role without superuser privileges, used when a separate
+// SuperuserName is configured.
+func createRole(config Config) (err error) {
+	conn, err := openDatabaseConnection(config.connectionHost(), config.port, config.superuser(), config.password, config.maintenanceDatabase)
+	if err != nil {
+		return err
+	}
+
+	db := sql.OpenDB(conn)
+	defer func() {
+		err = connectionClose(db, err)
+	}()
+
+	if _, err := db.Exec(fmt.Sprintf("CREATE ROLE %s LOGIN PASSWORD %s", pq.QuoteIdentifier(config.username), pq.QuoteLiteral(config.password))); err != nil {
+		return fmt.Errorf("unable to create role %s: %w", config.username, err)
+	}
+
+	return nil
+}
+
+// createRoles creates the configured Roles as the superuser.
+func createRoles(config Config) (err error) {
+	conn, err := openDatabaseConnection(config.connectionHost(), config.port, config.superuser(), config.password, config.maintenanceDatabase)
+	if err != nil {
+		return err
+	}
+
+	db := sql.OpenDB(conn)
+	defer func() {
+		err = connectionClose(db, err)
+	}()
+
+	for _, role := range config.roles {
+		if _, err := db.Exec(createRoleStatement(role)); err != nil {
+			return fmt.Errorf("unable to create role %s: %w", role.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// createRoleStatement returns the statement creating role with its attributes.
+func createRoleStatement(role RoleSpec) string {
+	statement := "CREATE ROLE " + pq.QuoteIdentifier(role.Name)
+
+	if role.Login {
+		statement += " LOGIN"
+	}
+
+	if role.Superuser {
+		statement += " SUPERUSER"
+	}
+
+	if role.CreateDB {
+		statement += " CREATEDB"
+	}
+
+	if role.Password != "" {
+		statement += " PASSWORD " + pq.QuoteLiteral(role.Password)
+	}
+
+	return statement
+}
+
+// dropDatabase terminates the connections to database and drops it as the superuser.
+func dropDatabase(config Config, database string) (err error) {
+	conn, err := openDatabaseConnection(config.connectionHost(), config.port, config.superuser(), config.password, config.maintenanceDatabase)
+	if err != nil {
+		return err
+	}
+
+	db := sql.OpenDB(conn)
+	defer func() {
+		err = connectionClose(db, err)
+	}()
+
+	if _, err := db.Exec("SELECT pg_terminate_backend(pid) FROM pg_stat_activity WHERE datname = $1 AND pid <> pg_backend_pid()", database); err != nil {
+		return fmt.Errorf("unable to terminate connections to database %s: %w", database, err)
+	}
+
+	if _, err := db.Exec("DROP DATABASE IF EXISTS " + pq.QuoteIdentifier(database)); err != nil {
+		return fmt.Errorf("unable to drop database %s: %w", database, err)
+	}
+
+	return nil
+}
+
+// createExtensions installs the configured extensions into the configured database as the superuser.
+func createExtensions(config Config) (err error) {
+	conn, err := openDatabaseConnection(config.connectionHost(), config.port, config.superuser(), config.password, config.database)
+	if err != nil {
+		return err
+	}
+
+	db := sql.OpenDB(conn)
+	defer func() {
+		err = connectionClose(db, err)
+	}()
+
+	for _, extension := range config.extensions {
+		if _, err := db.Exec("CREATE EXTENSION IF NOT EXISTS " + pq.QuoteIdentifier(extension)); err != nil {
+			return fmt.Errorf("unable to create extension %s: %w", extension, err)
+		}
+	}
+
+	return nil
+}
+
+// statStatementsAvailableQuery reports whether pg_stat_statements can be reset in the current database, which needs
+// both the extension to have been created and its library to be preloaded.
+const statStatementsAvailableQuery = "SELECT EXISTS (SELECT FROM pg_extension WHERE extname = 'pg_stat_statements') AND " +
+	"'pg_stat_statements' = ANY (string_to_array(replace(current_setting('shared_preload_libraries'), ' ', ''), ','))"
+
+// resetStats resets the statistics of the configured database as the superuser, including pg_stat_statements when it
+// is available.
+func resetStats(config Config) (err error) {
+	conn, err := openDatabaseConnection(config.connectionHost(), config.port, config.superuser(), config.password, config.database)
+	if err != nil {
+		return err
+	}
+
+	db := sql.OpenDB(conn)
+	defer func() {
+		err = connectionClose(db, err)
+	}()
+
+	if _, err := db.Exec("SELECT pg_stat_reset()"); err != nil {
+		return fmt.Errorf("unable to reset statistics of database %s: %w", config.database, err)
+	}
+
+	var statStatements bool
+	if err := db.QueryRow(statStatementsAvailableQuery).Scan(&statStatements); err != nil {
+		return fmt.Errorf("unable to check for pg_stat_statements in database %s: %w", config.database, err)
+	}
+
+	if !statStatements {
+		return nil
+	}
+
+	if _, err := db.Exec("SELECT pg_stat_statements_reset()"); err != nil {
+		return fmt.Errorf("unable to reset pg_stat_statements: %w", err)
+	}
+
+	return nil
+}
+
+// analyzeStatement gathers the statistics of every table in the database, reclaiming space left by deleted seed data.
+const analyzeStatement = "VACUUM ANALYZE"
+
+// analyzeDatabase runs analyzeStatement against the configured database as the superuser, who can vacuum every table.
+func analyzeDatabase(config Config) (err error) {
+	conn, err := openDatabaseConnection(config.connectionHost(), config.port, config.superuser(), config.password, config.database)
+	if err != nil {
+		return err
+	}
+
+	db := sql.OpenDB(conn)
+	defer func() {
+		err = connectionClose(db, err)
+	}()
+
+	if _, err := db.Exec(analyzeStatement); err != nil {
+		return fmt.Errorf("unable to vacuum analyze database %s: %w", config.database, err)
+	}
+
+	return nil
+}
+
+func runInitScripts(config Config) error {
+	for _, script := range config.initScripts {
+		content, err := os.ReadFile(script)
+		if err != nil {
+			return fmt.Errorf("unable to read init script %s: %w", script, err)
+		}
+
+		if err := execInSession(config, string(content)); err != nil {
+			return fmt.Errorf("unable to run init script %s: %w", script, err)
+		}
+	}
+
+	for _, statement := range config.initSQL {
+		if err := execInSession(config, statement); err != nil {
+			return fmt.Errorf("unable to run init SQL %q: %w", statement, err)
+		}
+	}
+
+	return nil
+}
+
+// runCallback invokes callback, such as OnReady or BeforeStop, with a connection to the configured database.
+func runCallback(config Config, callback func(db *sql.DB) error) (err error) {
+	conn, err := openDatabaseConnection(config.connectionHost(), config.port, config.username, config.password, config.database)
+	if err != nil {
+		return err
+	}
+
+	db := sql.OpenDB(conn)
+	defer func() {
+		err = connectionClose(db, err)
+	}()
+
+	return callback(db)
+}
+
+// execInSession runs the given SQL, which may contain multiple statements, in a single session against the configured database.
+func execInSession(config Config, query string) (err error) {
+	conn, err := openDatabaseConnection(config.connectionHost(), config.port, config.username, config.password, config.database)
+	if err != nil {
+		return err
+	}
+
+	db := sql.OpenDB(conn)
+	defer func() {
+		err = connectionClose(db, err)
+	}()
+
+	if _, err := db.Exec(query); err != nil {
+		return err
+	}
+
+	return nil
+}
+
 // connectionClose closes the database connection and handles the error of the function that used the database connection
 func connectionClose(db io.Closer, err error) error {
 	closeErr := db.Close()
@@ -109,58 +376,122 @@ func connectionClose(db io.Closer, err error) error {
 	return err
 }
 
-func healthCheckDatabaseOrTimeout(config Config) error {
-	healthCheckSignal := make(chan bool)
+// healthCheckDatabaseOrTimeout polls the configured database until a query succeeds, giving up after StartTimeout.
+// On timeout the error from the last attempt is included so the cause is not lost.
+func healthCheckDatabaseOrTimeout(ctx context.Context, config Config) error {
+	timeout, cancelFunc := context.WithTimeout(ctx, config.startTimeout)
 
-	defer close(healthCheckSignal)
+	defer cancelFunc()
 
-	timeout, cancelFunc := context.WithTimeout(context.Background(), config.startTimeout)
+	var lastErr error
 
-	defer cancelFunc()
+	for timeout.Err() == nil {
+		err := healthCheckDatabaseAttempt(timeout, config)
+		if err == nil {
+			return nil
+		}
 
-	go func() {
-		for timeout.Err() == nil {
-			if err := healthCheckDatabase(config.port, config.database, config.username, config.password); err != nil {
-				continue
-			}
-			healthCheckSignal <- true
+		// an attempt interrupted by the timeout says nothing about why the database is unavailable
+		if lastErr == nil || timeout.Err() == nil {
+			lastErr = err
+		}
 
-			break
+		select {
+		case <-timeout.Done():
+		case <-time.After(config.healthCheckInterval):
 		}
-	}()
+	}
 
-	select {
-	case <-healthCheckSignal:
-		return nil
-	case <-timeout.Done():
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if lastErr == nil {
 		return errors.New("timed out waiting for database to become available")
 	}
+
+	return fmt.Errorf("timed out waiting for database to become available: %w", lastErr)
 }
 
-func healthCheckDatabase(port uint32, database, username, password string) (err error) {
-	conn, err := openDatabaseConnection(port, username, password, database)
+// healthCheckDatabaseAttempt runs a single health check, abandoning it after HealthCheckConnectTimeout.
+func healthCheckDatabaseAttempt(ctx context.Context, config Config) error {
+	if config.healthCheckConnectTimeout > 0 {
+		var cancelFunc context.CancelFunc
+		ctx, cancelFunc = context.WithTimeout(ctx, config.healthCheckConnectTimeout)
+
+		defer cancelFunc()
+	}
+
+	return healthCheckDatabase(ctx, config.connectionHost(), config.port, config.database, config.username, config.password, config.healthCheckQuery)
+}
+
+// healthCheckDatabase runs query against the given database, ensuring it exists and is accepting queries.
+// If query is empty "SELECT 1" is used.
+func healthCheckDatabase(ctx context.Context, host string, port uint32, database, username, password, query string) (err error) {
+	conn, err := openDatabaseConnection(host, port, username, password, database)
 	if err != nil {
 		return err
 	}
 
+	conn.Dialer(&deadlineDialer{})
+
 	db := sql.OpenDB(conn)
 	defer func() {
 		err = connectionClose(db, err)
 	}()
 
-	if _, err := db.Query("SELECT 1"); err != nil {
+	if query == "" {
+		query = "SELECT 1"
+	}
+
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
 		return err
 	}
 
-	return nil
+	defer func() {
+		if closeErr := rows.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+
+	for rows.Next() {
+	}
+
+	return rows.Err()
 }
 
-func openDatabaseConnection(port uint32, username string, password string, database string) (*pq.Connector, error) {
-	conn, err := pq.NewConnector(fmt.Sprintf("host=localhost port=%d user=%s password=%s dbname=%s sslmode=disable",
-		port,
-		username,
-		password,
-		database))
+// deadlineDialer applies the deadline of the context it dials with to the connection, as lib/pq does not otherwise
+// interrupt a server which accepts a connection but stalls during the startup handshake.
+type deadlineDialer struct {
+	net.Dialer
+}
+
+func (d deadlineDialer) DialTimeout(network, address string, timeout time.Duration) (net.Conn, error) {
+	ctx, cancelFunc := context.WithTimeout(context.Background(), timeout)
+	defer cancelFunc()
+
+	return d.DialContext(ctx, network, address)
+}
+
+func (d deadlineDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	conn, err := d.Dialer.DialContext(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+	}
+
+	return conn, nil
+}
+
+func openDatabaseConnection(host string, port uint32, username string, password string, database string) (*pq.Connector, error) {
+	conn, err := pq.NewConnector(connectionString(host, port, username, password, database, "disable"))
 	if err != nil {
 		return nil, err
 	}
@@ -168,6 +499,26 @@ func openDatabaseConnection(port uint32, username string, password string, datab
 	return conn, nil
 }
 
+func connectionString(host string, port uint32, username string, password string, database string, sslMode string) string {
+	return fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=%s",
+		connectionValue(host),
+		port,
+		connectionValue(username),
+		connectionValue(password),
+		connectionValue(database),
+		sslMode)
+}
+
+// connectionValue quotes a value for a key=value connection string when it is empty or contains spaces, quotes or
+// backslashes.
+func connectionValue(value string) string {
+	if value != "" && !strings.ContainsAny(value, " '\\") {
+		return value
+	}
+
+	return "'" + strings.NewReplacer("\\", "\\\\", "'", "\\'").Replace(value) + "'"
+}
+
 func errorCustomDatabase(database string, err error) error {
 	return fmt.Errorf("unable to connect to create database with custom name %s with the following error: %s", database, err)
 }
@@ -1,18 +1,24 @@
 package embeddedpostgres
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"net"
 	"os"
 	"path/filepath"
+	"runtime"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func Test_defaultInitDatabase_ErrorWhenCannotCreatePasswordFile(t *testing.T) {
-	err := defaultInitDatabase("path_not_exists", "path_not_exists", "path_not_exists", "Tom", "Beer", "", "", os.Stderr)
+	err := defaultInitDatabase(context.Background(), "path_not_exists", "path_not_exists", "path_not_exists", "Tom", "Beer", "", "", nil, nil, os.Stderr)
 
 	assert.EqualError(t, err, "unable to write password file to path_not_exists/pwfile")
 }
@@ -49,7 +55,7 @@ func Test_defaultInitDatabase_ErrorWhenCannotStartInitDBProcess(t *testing.T) {
 
 	_, _ = logFile.Write([]byte("and here are the logs!"))
 
-	err = defaultInitDatabase(binTempDir, runtimeTempDir, filepath.Join(runtimeTempDir, "data"), "Tom", "Beer", "", "", logFile)
+	err = defaultInitDatabase(context.Background(), filepath.Join(binTempDir, "bin", "initdb"), runtimeTempDir, filepath.Join(runtimeTempDir, "data"), "Tom", "Beer", "", "", nil, nil, logFile)
 
 	assert.NotNil(t, err)
 	assert.Contains(t, err.Error(), fmt.Sprintf("unable to init database using '%s/bin/initdb -A password -U Tom -D %s/data --pwfile=%s/pwfile'",
@@ -57,7 +63,55 @@ func Test_defaultInitDatabase_ErrorWhenCannotStartInitDBProcess(t *testing.T) {
 		runtimeTempDir,
 		runtimeTempDir))
 	assert.Contains(t, err.Error(), "and here are the logs!")
-	assert.FileExists(t, filepath.Join(runtimeTempDir, "pwfile"))
+	assert.NoFileExists(t, filepath.Join(runtimeTempDir, "pwfile"))
+}
+
+func Test_defaultInitDatabase_PasswordNotExposed(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake binaries are shell scripts")
+	}
+
+	binTempDir := t.TempDir()
+	runtimeTempDir := t.TempDir()
+
+	require.NoError(t, os.MkdirAll(filepath.Join(binTempDir, "bin"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(binTempDir, "bin", "initdb"), []byte("#!/bin/sh\necho \"$@\"\nenv\nexit 1\n"), 0755))
+
+	logFile, err := os.CreateTemp(t.TempDir(), "log")
+	require.NoError(t, err)
+
+	defer logFile.Close()
+
+	err = defaultInitDatabase(context.Background(), filepath.Join(binTempDir, "bin", "initdb"), runtimeTempDir, filepath.Join(runtimeTempDir, "data"), "Tom", "s3cr3t-Beer", "", "", nil, nil, logFile)
+	require.Error(t, err)
+
+	logContent, readErr := os.ReadFile(logFile.Name())
+	require.NoError(t, readErr)
+
+	assert.Contains(t, string(logContent), "--pwfile=")
+	assert.NotContains(t, string(logContent), "s3cr3t-Beer")
+	assert.NotContains(t, err.Error(), "s3cr3t-Beer")
+	assert.NoFileExists(t, filepath.Join(runtimeTempDir, "pwfile"))
+}
+
+func Test_defaultInitDatabase_AppendsParameters(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "prepare_database_test")
+	if err != nil {
+		panic(err)
+	}
+
+	defer func() {
+		if err := os.RemoveAll(tempDir); err != nil {
+			panic(err)
+		}
+	}()
+
+	err = defaultInitDatabase(context.Background(), filepath.Join(tempDir, "bin", "initdb"), tempDir, filepath.Join(tempDir, "data"), "Tom", "Beer", "", "UTF8", []string{"--data-checksums", "--wal-segsize=32"}, nil, os.Stderr)
+
+	assert.ErrorContains(t, err, fmt.Sprintf("unable to init database using '%s/bin/initdb -A password -U Tom -D %s/data --pwfile=%s/pwfile --encoding=UTF8 --data-checksums --wal-segsize=32'",
+		tempDir,
+		tempDir,
+		tempDir))
 }
 
 func Test_defaultInitDatabase_ErrorInvalidLocaleSetting(t *testing.T) {
@@ -72,7 +126,7 @@ func Test_defaultInitDatabase_ErrorInvalidLocaleSetting(t *testing.T) {
 		}
 	}()
 
-	err = defaultInitDatabase(tempDir, tempDir, filepath.Join(tempDir, "data"), "postgres", "postgres", "en_XY", "", os.Stderr)
+	err = defaultInitDatabase(context.Background(), filepath.Join(tempDir, "bin", "initdb"), tempDir, filepath.Join(tempDir, "data"), "postgres", "postgres", "en_XY", "", nil, nil, os.Stderr)
 
 	assert.NotNil(t, err)
 	assert.Contains(t, err.Error(), fmt.Sprintf("unable to init database using '%s/bin/initdb -A password -U postgres -D %s/data --pwfile=%s/pwfile --locale=en_XY'",
@@ -93,7 +147,7 @@ func Test_defaultInitDatabase_ErrorInvalidEncodingSetting(t *testing.T) {
 		}
 	}()
 
-	err = defaultInitDatabase(tempDir, tempDir, filepath.Join(tempDir, "data"), "postgres", "postgres", "", "invalid", os.Stderr)
+	err = defaultInitDatabase(context.Background(), filepath.Join(tempDir, "bin", "initdb"), tempDir, filepath.Join(tempDir, "data"), "postgres", "postgres", "", "invalid", nil, nil, os.Stderr)
 
 	assert.NotNil(t, err)
 	assert.Contains(t, err.Error(), fmt.Sprintf("unable to init database using '%s/bin/initdb -A password -U postgres -D %s/data --pwfile=%s/pwfile --encoding=invalid'",
@@ -131,10 +185,38 @@ func Test_defaultInitDatabase_PwFileRemoved(t *testing.T) {
 	assert.True(t, os.IsNotExist(err), "pwfile (%v) still exists after starting the db", pwFile)
 }
 
-func Test_defaultCreateDatabase_ErrorWhenSQLOpenError(t *testing.T) {
-	err := defaultCreateDatabase(1234, "user client_encoding=lol", "password", "database")
+func Test_defaultCreateDatabase_UsernameNotParsedAsParameters(t *testing.T) {
+	err := defaultCreateDatabase("localhost", 1234, "user client_encoding=lol", "password", "postgres", "database", "")
+
+	assert.ErrorContains(t, err, "unable to connect to create database with custom name database with the following error: dial tcp")
+}
+
+func Test_defaultCreateDatabase_SkipsMaintenanceDatabase(t *testing.T) {
+	assert.NoError(t, defaultCreateDatabase("localhost", 1234, "postgres", "postgres", "template1", "template1", ""))
+}
+
+func Test_createDatabaseStatement_PostgresWithOtherMaintenanceDatabase(t *testing.T) {
+	assert.Equal(t, `CREATE DATABASE "postgres"`, createDatabaseStatement("postgres", "template1", "postgres", "postgres"))
+	assert.Empty(t, createDatabaseStatement("postgres", "postgres", "postgres", "postgres"))
+}
+
+func Test_defaultCreateDatabase_MaintenanceDatabase(t *testing.T) {
+	database := NewDatabase(DefaultConfig().
+		Port(9833).
+		MaintenanceDatabase("template1").
+		Database("beer"))
+
+	if err := database.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	err := database.RecreateDatabase()
+
+	if err := database.Stop(); err != nil {
+		t.Fatal(err)
+	}
 
-	assert.EqualError(t, err, "unable to connect to create database with custom name database with the following error: client_encoding must be absent or 'UTF8'")
+	assert.NoError(t, err)
 }
 
 func Test_defaultCreateDatabase_DashesInName(t *testing.T) {
@@ -165,15 +247,82 @@ func Test_defaultCreateDatabase_ErrorWhenQueryError(t *testing.T) {
 		}
 	}()
 
-	err := defaultCreateDatabase(9831, "postgres", "postgres", "b33r")
+	err := defaultCreateDatabase("localhost", 9831, "postgres", "postgres", "postgres", "b33r", "")
 
 	assert.EqualError(t, err, `unable to connect to create database with custom name b33r with the following error: pq: database "b33r" already exists`)
 }
 
-func Test_healthCheckDatabase_ErrorWhenSQLConnectingError(t *testing.T) {
-	err := healthCheckDatabase(1234, "tom client_encoding=lol", "more", "b33r")
+func Test_healthCheckDatabase_UsernameNotParsedAsParameters(t *testing.T) {
+	err := healthCheckDatabase(context.Background(), "localhost", 1234, "tom client_encoding=lol", "more", "b33r", "")
 
-	assert.EqualError(t, err, "client_encoding must be absent or 'UTF8'")
+	assert.ErrorContains(t, err, "dial tcp")
+}
+
+func Test_healthCheckDatabaseOrTimeout_IntervalDoesNotExceedTimeout(t *testing.T) {
+	config := DefaultConfig().
+		Port(9).
+		StartTimeout(200 * time.Millisecond).
+		HealthCheckInterval(time.Hour)
+
+	started := time.Now()
+	err := healthCheckDatabaseOrTimeout(context.Background(), config)
+
+	assert.ErrorContains(t, err, "timed out waiting for database to become available")
+	assert.Less(t, time.Since(started), 10*time.Second)
+}
+
+func Test_healthCheckDatabaseOrTimeout_RetriesStalledConnections(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	defer listener.Close()
+
+	var connections []net.Conn
+
+	accepted := make(chan struct{})
+	go func() {
+		defer close(accepted)
+
+		for {
+			// accept connections but never respond, as a stalled server would
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			connections = append(connections, conn)
+		}
+	}()
+
+	config := DefaultConfig().
+		BindAddress("127.0.0.1").
+		Port(uint32(listener.Addr().(*net.TCPAddr).Port)).
+		StartTimeout(time.Second).
+		HealthCheckConnectTimeout(100 * time.Millisecond)
+
+	err = healthCheckDatabaseOrTimeout(context.Background(), config)
+
+	assert.ErrorContains(t, err, "timed out waiting for database to become available")
+
+	require.NoError(t, listener.Close())
+	<-accepted
+
+	assert.Greater(t, len(connections), 1)
+
+	for _, conn := range connections {
+		require.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+
+		// the health check closed its end, the startup message may be read before EOF
+		_, err := io.Copy(io.Discard, conn)
+		assert.NoError(t, err)
+		_ = conn.Close()
+	}
+}
+
+func Test_runInitScripts_ErrorWhenScriptMissing(t *testing.T) {
+	err := runInitScripts(DefaultConfig().InitScripts("/does-not-exist.sql"))
+
+	assert.EqualError(t, err, "unable to read init script /does-not-exist.sql: open /does-not-exist.sql: no such file or directory")
 }
 
 type CloserWithoutErr struct{}
@@ -269,3 +418,18 @@ func TestConnCloserWithErr(t *testing.T) {
 		})
 	}
 }
+
+func Test_createRoleStatement(t *testing.T) {
+	assert.Equal(t, `CREATE ROLE "group"`, createRoleStatement(RoleSpec{Name: "group"}))
+	assert.Equal(t, `CREATE ROLE "admin" LOGIN SUPERUSER CREATEDB PASSWORD 'it''s a secret'`,
+		createRoleStatement(RoleSpec{Name: "admin", Password: "it's a secret", Login: true, Superuser: true, CreateDB: true}))
+	assert.Equal(t, `CREATE ROLE "gin ""tonic""" LOGIN PASSWORD 'wine'`,
+		createRoleStatement(RoleSpec{Name: `gin "tonic"`, Password: "wine", Login: true}))
+}
+
+func Test_connectionString_QuotesValues(t *testing.T) {
+	assert.Equal(t, `host=localhost port=5432 user='gin "tonic"' password='it\'s a \\secret' dbname='cidre à la poire' sslmode=disable`,
+		connectionString("localhost", 5432, `gin "tonic"`, `it's a \secret`, "cidre à la poire", "disable"))
+	assert.Equal(t, "host=localhost port=5432 user=gin password='' dbname=beer sslmode=disable",
+		connectionString("localhost", 5432, "gin", "", "beer", "disable"))
+}
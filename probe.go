@@ -0,0 +1,80 @@
+package embeddedpostgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// ProbeResult describes the state of the Postgres server found by Probe.
+type ProbeResult struct {
+	// Connected is true when a session could be opened on MaintenanceDatabase.
+	Connected bool
+	// DatabaseExists is true when the configured Database exists.
+	DatabaseExists bool
+	// Ready is true when the HealthCheckQuery succeeded against the configured Database.
+	Ready bool
+	// ServerVersion is the version reported by the server, e.g. "16.4".
+	ServerVersion string
+	// Uptime is the time since the server was started.
+	Uptime time.Duration
+	// Err is the error which stopped the probe, nil when Ready.
+	Err error
+}
+
+// probeQuery reports the server version, the seconds since it started and whether the database given as $1 exists.
+const probeQuery = "SELECT current_setting('server_version'), EXTRACT(EPOCH FROM now() - pg_postmaster_start_time())::float8, " +
+	"EXISTS (SELECT FROM pg_database WHERE datname = $1)"
+
+// Probe connects to the server as the configured user to report its state in detail, e.g. for the readiness endpoint
+// of a test harness, rather than the pass or fail of WaitUntilReady. It first connects to MaintenanceDatabase, so that
+// a missing Database is reported as such, then runs the HealthCheckQuery against Database. Each connection is
+// abandoned after HealthCheckConnectTimeout or when ctx is done. Like WaitUntilReady it does not require the server to
+// have been started by Start.
+func (ep *EmbeddedPostgres) Probe(ctx context.Context) ProbeResult {
+	var result ProbeResult
+
+	if ep.config.healthCheckConnectTimeout > 0 {
+		var cancelFunc context.CancelFunc
+		ctx, cancelFunc = context.WithTimeout(ctx, ep.config.healthCheckConnectTimeout)
+
+		defer cancelFunc()
+	}
+
+	conn, err := openDatabaseConnection(ep.config.connectionHost(), ep.config.port, ep.config.username, ep.config.password, ep.config.maintenanceDatabase)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+
+	conn.Dialer(&deadlineDialer{})
+
+	db := sql.OpenDB(conn)
+	defer func() {
+		_ = db.Close()
+	}()
+
+	var uptimeSeconds float64
+	if err := db.QueryRowContext(ctx, probeQuery, ep.config.database).Scan(&result.ServerVersion, &uptimeSeconds, &result.DatabaseExists); err != nil {
+		result.Err = err
+		return result
+	}
+
+	result.Connected = true
+	result.Uptime = time.Duration(uptimeSeconds * float64(time.Second))
+
+	if !result.DatabaseExists {
+		result.Err = fmt.Errorf("database %s does not exist", ep.config.database)
+		return result
+	}
+
+	if err := healthCheckDatabaseAttempt(ctx, ep.config); err != nil {
+		result.Err = err
+		return result
+	}
+
+	result.Ready = true
+
+	return result
+}
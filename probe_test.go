@@ -0,0 +1,67 @@
+package embeddedpostgres
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Probe_NotRunning(t *testing.T) {
+	port, err := ensurePortAvailable("localhost", 0)
+	require.NoError(t, err)
+
+	result := NewDatabase(DefaultConfig().Port(port)).Probe(context.Background())
+
+	assert.False(t, result.Connected)
+	assert.False(t, result.DatabaseExists)
+	assert.False(t, result.Ready)
+	assert.Error(t, result.Err)
+}
+
+func Test_Probe_ContextDone(t *testing.T) {
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	cancelFunc()
+
+	result := NewDatabase(DefaultConfig().Port(9890)).Probe(ctx)
+
+	assert.False(t, result.Connected)
+	assert.ErrorIs(t, result.Err, context.Canceled)
+}
+
+func Test_Probe(t *testing.T) {
+	database := NewDatabase(DefaultConfig().
+		Port(9890).
+		Database("beer"))
+
+	if err := database.Start(); err != nil {
+		shutdownDBAndFatal(t, err, database)
+	}
+
+	ready := database.Probe(context.Background())
+
+	missing := NewDatabase(DefaultConfig().Port(9890).Database("cider")).Probe(context.Background())
+
+	if err := database.Stop(); err != nil {
+		shutdownDBAndFatal(t, err, database)
+	}
+
+	stopped := database.Probe(context.Background())
+
+	assert.NoError(t, ready.Err)
+	assert.True(t, ready.Connected)
+	assert.True(t, ready.DatabaseExists)
+	assert.True(t, ready.Ready)
+	assert.Equal(t, "16.4", ready.ServerVersion)
+	assert.Greater(t, ready.Uptime, time.Duration(0))
+
+	assert.True(t, missing.Connected)
+	assert.False(t, missing.DatabaseExists)
+	assert.False(t, missing.Ready)
+	assert.EqualError(t, missing.Err, "database cider does not exist")
+
+	assert.False(t, stopped.Connected)
+	assert.Error(t, stopped.Err)
+}
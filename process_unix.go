@@ -0,0 +1,14 @@
+//go:build !windows
+// +build !windows
+
+package embeddedpostgres
+
+import (
+	"syscall"
+)
+
+// processExists reports whether a process with the given ID is running.
+func processExists(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
@@ -0,0 +1,24 @@
+//go:build windows
+// +build windows
+
+package embeddedpostgres
+
+import (
+	"syscall"
+)
+
+// processExists reports whether a process with the given ID is running.
+func processExists(pid int) bool {
+	handle, err := syscall.OpenProcess(syscall.SYNCHRONIZE, false, uint32(pid))
+	if err != nil {
+		return false
+	}
+
+	defer func() {
+		_ = syscall.CloseHandle(handle)
+	}()
+
+	event, err := syscall.WaitForSingleObject(handle, 0)
+
+	return err == nil && event == syscall.WAIT_TIMEOUT
+}
@@ -0,0 +1,74 @@
+package embeddedpostgres
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	psqlFieldSeparator  = "\x1f"
+	psqlRecordSeparator = "\x1e"
+)
+
+// Exec runs sql against database using the bundled psql, so that simple setup such as a CREATE TABLE does not need
+// a database driver. Multiple statements separated by semicolons are run in a single transaction and the first
+// failing statement aborts the rest.
+func (ep *EmbeddedPostgres) Exec(database, sql string) error {
+	if !ep.started {
+		return ErrServerNotStarted
+	}
+
+	if _, err := runClientTool(ep.config, "psql", psqlArgs(ep.config, database, sql)...); err != nil {
+		return fmt.Errorf("unable to execute sql in database %s: %w", database, err)
+	}
+
+	return nil
+}
+
+// Query runs sql against database using the bundled psql and returns the rows of the last statement's result, each
+// row holding the text representation of its columns. NULL values are returned as empty strings.
+func (ep *EmbeddedPostgres) Query(database, sql string) ([][]string, error) {
+	if !ep.started {
+		return nil, ErrServerNotStarted
+	}
+
+	args := append(psqlArgs(ep.config, database, sql),
+		"--no-align",
+		"--tuples-only",
+		"--field-separator", psqlFieldSeparator,
+		"--record-separator", psqlRecordSeparator,
+	)
+
+	output, err := runClientTool(ep.config, "psql", args...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to query database %s: %w", database, err)
+	}
+
+	return parsePsqlRows(string(output)), nil
+}
+
+func psqlArgs(config Config, database, sql string) []string {
+	return append(clientConnectionArgs(config, database),
+		"--no-psqlrc",
+		"--quiet",
+		"--set", "ON_ERROR_STOP=1",
+		"--command", sql,
+	)
+}
+
+// parsePsqlRows splits the unaligned output of psql, which ends each result with a newline, into rows and columns.
+func parsePsqlRows(output string) [][]string {
+	output = strings.TrimSuffix(output, "\n")
+	if output == "" {
+		return [][]string{}
+	}
+
+	records := strings.Split(output, psqlRecordSeparator)
+	rows := make([][]string, 0, len(records))
+
+	for _, record := range records {
+		rows = append(rows, strings.Split(record, psqlFieldSeparator))
+	}
+
+	return rows
+}
@@ -0,0 +1,60 @@
+package embeddedpostgres
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ErrorWhenExecCalledBeforeStart(t *testing.T) {
+	database := NewDatabase()
+
+	err := database.Exec("postgres", "SELECT 1")
+
+	assert.ErrorIs(t, err, ErrServerNotStarted)
+}
+
+func Test_ErrorWhenQueryCalledBeforeStart(t *testing.T) {
+	database := NewDatabase()
+
+	_, err := database.Query("postgres", "SELECT 1")
+
+	assert.ErrorIs(t, err, ErrServerNotStarted)
+}
+
+func Test_psqlArgs(t *testing.T) {
+	config := DefaultConfig().Port(9876).Username("gin")
+
+	assert.Equal(t,
+		[]string{"--host", "localhost", "--port", "9876", "--username", "gin", "--dbname", "beer", "--no-password", "--no-psqlrc", "--quiet", "--set", "ON_ERROR_STOP=1", "--command", "SELECT 1"},
+		psqlArgs(config, "beer", "SELECT 1"))
+}
+
+func Test_parsePsqlRows(t *testing.T) {
+	assert.Equal(t, [][]string{}, parsePsqlRows(""))
+	assert.Equal(t, [][]string{{"1", "stout"}}, parsePsqlRows("1\x1fstout\n"))
+	assert.Equal(t,
+		[][]string{{"1", "stout\nporter"}, {"2", ""}},
+		parsePsqlRows("1\x1fstout\nporter\x1e2\x1f\n"))
+}
+
+func Test_ExecAndQuery(t *testing.T) {
+	database := NewDatabase()
+
+	if err := database.Start(); err != nil {
+		shutdownDBAndFatal(t, err, database)
+	}
+
+	execErr := database.Exec("postgres", "CREATE TABLE beers (id INT, name TEXT); INSERT INTO beers VALUES (1, 'stout'), (2, NULL)")
+	rows, queryErr := database.Query("postgres", "SELECT id, name FROM beers ORDER BY id")
+	failingErr := database.Exec("postgres", "SELECT * FROM wines")
+
+	if err := database.Stop(); err != nil {
+		shutdownDBAndFatal(t, err, database)
+	}
+
+	assert.NoError(t, execErr)
+	assert.NoError(t, queryErr)
+	assert.Equal(t, [][]string{{"1", "stout"}, {"2", ""}}, rows)
+	assert.ErrorContains(t, failingErr, `relation "wines" does not exist`)
+}
@@ -3,66 +3,287 @@ package embeddedpostgres
 import (
 	"archive/zip"
 	"bytes"
+	"context"
+	"crypto/sha1" //nolint:gosec
 	"crypto/sha256"
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"hash"
 	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
+	"runtime"
 	"strings"
+	"time"
 )
 
+// errVersionNotFound is returned when the binary repository has no artifact for the requested version.
+var errVersionNotFound = errors.New("no version found")
+
 // RemoteFetchStrategy provides a strategy to fetch a Postgres binary so that it is available for use.
-type RemoteFetchStrategy func() error
-
-//nolint:funlen
-func defaultRemoteFetchStrategy(remoteFetchHost string, versionStrategy VersionStrategy, cacheLocator CacheLocator) RemoteFetchStrategy {
-	return func() error {
-		operatingSystem, architecture, version := versionStrategy()
-
-		jarDownloadURL := fmt.Sprintf("%s/io/zonky/test/postgres/embedded-postgres-binaries-%s-%s/%s/embedded-postgres-binaries-%s-%s-%s.jar",
-			remoteFetchHost,
-			operatingSystem,
-			architecture,
-			version,
-			operatingSystem,
-			architecture,
-			version)
-
-		jarDownloadResponse, err := http.Get(jarDownloadURL)
+// The fetch should be abandoned when the supplied context is cancelled.
+type RemoteFetchStrategy func(ctx context.Context) error
+
+func defaultRemoteFetchStrategy(config Config, versionStrategy VersionStrategy, cacheLocator CacheLocator) RemoteFetchStrategy {
+	// credentials are sent as a header, keeping them out of any URL that ends up in logs or errors
+	config = moveRepositoryCredentialsFromURL(config)
+
+	return func(ctx context.Context) error {
+		jarBodyBytes, contentLength, jarDownloadURL, err := downloadBinaryJar(ctx, config, versionStrategy)
 		if err != nil {
-			return fmt.Errorf("unable to connect to %s", remoteFetchHost)
+			return err
 		}
 
-		defer closeBody(jarDownloadResponse)()
+		return decompressResponse(jarBodyBytes, contentLength, cacheLocator, jarDownloadURL)
+	}
+}
+
+// defaultStreamFetchStrategy returns the fetch strategy used by StreamExtract, which extracts the binaries archive
+// into extractPath as it is decompressed from the downloaded jar rather than caching it. The jar itself is held in
+// memory as its zip format is read from the end.
+func defaultStreamFetchStrategy(config Config, versionStrategy VersionStrategy) func(extractPath string) RemoteFetchStrategy {
+	config = moveRepositoryCredentialsFromURL(config)
+	tarReader := configuredTarReader(config)
+
+	return func(extractPath string) RemoteFetchStrategy {
+		return func(ctx context.Context) error {
+			jarBodyBytes, contentLength, jarDownloadURL, err := downloadBinaryJar(ctx, config, versionStrategy)
+			if err != nil {
+				return err
+			}
+
+			file, err := findCompressedTar(jarBodyBytes, contentLength, jarDownloadURL)
+			if err != nil {
+				return err
+			}
+
+			archive, err := file.Open()
+			if err != nil {
+				return errorExtractingPostgres(err)
+			}
+
+			defer func() {
+				_ = archive.Close()
+			}()
 
-		if jarDownloadResponse.StatusCode != http.StatusOK {
-			return fmt.Errorf("no version found matching %s", version)
+			return extractTar(ctx, tarReader, archive, jarDownloadURL, extractPath)
 		}
+	}
+}
 
-		jarBodyBytes, err := io.ReadAll(jarDownloadResponse.Body)
-		if err != nil {
-			return errorFetchingPostgres(err)
+// downloadBinaryJar downloads the jar holding the binaries chosen by versionStrategy, verifying its checksum unless
+// DisableChecksumVerification is set. It returns the jar along with its content length and download URL.
+func downloadBinaryJar(ctx context.Context, config Config, versionStrategy VersionStrategy) ([]byte, int64, string, error) {
+	operatingSystem, architecture, version := versionStrategy()
+
+	if err := checkPublishedPlatform(operatingSystem, architecture); err != nil {
+		return nil, 0, "", err
+	}
+
+	jarDownloadURL := binaryDownloadURL(config.binaryRepositoryURL, operatingSystem, architecture, version)
+
+	jarDownloadResponse, err := httpGetWithRetries(ctx, config, jarDownloadURL)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, 0, "", ctx.Err()
+		}
+
+		return nil, 0, "", fmt.Errorf("unable to connect to %s", config.binaryRepositoryURL)
+	}
+
+	defer closeBody(jarDownloadResponse)()
+
+	// only a missing artifact means the version was not published, other statuses are server or transport failures
+	if jarDownloadResponse.StatusCode == http.StatusNotFound {
+		return nil, 0, "", fmt.Errorf("%w matching %s, %s returned %s", errVersionNotFound, version, jarDownloadURL, jarDownloadResponse.Status)
+	}
+
+	if jarDownloadResponse.StatusCode != http.StatusOK {
+		return nil, 0, "", fmt.Errorf("unable to download Postgres %s, %s returned %s", version, jarDownloadURL, jarDownloadResponse.Status)
+	}
+
+	jarBodyBytes, err := io.ReadAll(jarDownloadResponse.Body)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, 0, "", ctx.Err()
 		}
 
-		shaDownloadURL := fmt.Sprintf("%s.sha256", jarDownloadURL)
-		shaDownloadResponse, err := http.Get(shaDownloadURL)
+		return nil, 0, "", errorFetchingPostgres(err)
+	}
 
-		defer closeBody(shaDownloadResponse)()
+	if !config.disableChecksumVerification {
+		if err := verifyChecksum(ctx, config, jarDownloadURL, jarBodyBytes); err != nil {
+			return nil, 0, "", err
+		}
+	}
 
-		if err == nil && shaDownloadResponse.StatusCode == http.StatusOK {
-			if shaBodyBytes, err := io.ReadAll(shaDownloadResponse.Body); err == nil {
-				jarChecksum := sha256.Sum256(jarBodyBytes)
-				if !bytes.Equal(shaBodyBytes, []byte(hex.EncodeToString(jarChecksum[:]))) {
-					return errors.New("downloaded checksums do not match")
-				}
+	return jarBodyBytes, jarDownloadResponse.ContentLength, jarDownloadURL, nil
+}
+
+// binaryDownloadURL returns the URL of the Maven artifact holding the binaries for the given platform and version.
+func binaryDownloadURL(repositoryURL, operatingSystem, architecture string, version PostgresVersion) string {
+	return fmt.Sprintf("%s/io/zonky/test/postgres/embedded-postgres-binaries-%s-%s/%s/embedded-postgres-binaries-%s-%s-%s.jar",
+		repositoryURL,
+		operatingSystem,
+		architecture,
+		version,
+		operatingSystem,
+		architecture,
+		version)
+}
+
+// verifyChecksum compares the downloaded bytes against the first checksum published for downloadURL.
+// An error is returned if the checksums differ or no checksum is published at all.
+func verifyChecksum(ctx context.Context, config Config, downloadURL string, downloadedBytes []byte) error {
+	// digests published alongside Maven artifacts, in order of preference
+	checksumAlgorithms := []struct {
+		extension string
+		newHash   func() hash.Hash
+	}{
+		{extension: "sha256", newHash: sha256.New},
+		{extension: "sha1", newHash: sha1.New},
+	}
+
+	for _, algorithm := range checksumAlgorithms {
+		checksumURL := fmt.Sprintf("%s.%s", downloadURL, algorithm.extension)
+
+		checksumResponse, err := httpGet(ctx, config, checksumURL)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
 			}
+
+			return fmt.Errorf("unable to fetch checksum from %s: %s", checksumURL, err)
+		}
+
+		if checksumResponse.StatusCode != http.StatusOK {
+			closeBody(checksumResponse)()
+			continue
+		}
+
+		checksumBytes, err := io.ReadAll(checksumResponse.Body)
+		closeBody(checksumResponse)()
+
+		if err != nil {
+			return fmt.Errorf("unable to fetch checksum from %s: %s", checksumURL, err)
 		}
 
-		return decompressResponse(jarBodyBytes, jarDownloadResponse.ContentLength, cacheLocator, jarDownloadURL)
+		// checksum files may contain the file name after the digest
+		expected := strings.ToLower(strings.TrimSpace(string(checksumBytes)))
+		if fields := strings.Fields(expected); len(fields) > 0 {
+			expected = fields[0]
+		}
+
+		digest := algorithm.newHash()
+		_, _ = digest.Write(downloadedBytes)
+		actual := hex.EncodeToString(digest.Sum(nil))
+
+		if expected != actual {
+			return fmt.Errorf("checksum mismatch for %s: expected %s %s but downloaded archive has %s",
+				downloadURL,
+				algorithm.extension,
+				expected,
+				actual)
+		}
+
+		return nil
+	}
+
+	return fmt.Errorf("no checksum published for %s, configure DisableChecksumVerification to skip verification", downloadURL)
+}
+
+func httpGet(ctx context.Context, config Config, downloadURL string) (*http.Response, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, downloadURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.binaryRepositoryUsername != "" || config.binaryRepositoryPassword != "" {
+		request.SetBasicAuth(config.binaryRepositoryUsername, config.binaryRepositoryPassword)
+	}
+
+	client := config.httpClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	if request.URL.Scheme == "file" {
+		client = &http.Client{Transport: http.NewFileTransport(localFileSystem{})}
+	}
+
+	return client.Do(request)
+}
+
+// localFileSystem serves the absolute paths of file:// URLs, so a BinaryRepositoryURL such as file:///opt/maven2 can
+// point at a Maven repository mirrored to disk.
+type localFileSystem struct{}
+
+func (localFileSystem) Open(name string) (http.File, error) {
+	path := filepath.FromSlash(name)
+	if runtime.GOOS == "windows" {
+		// file:///C:/maven2 has the path /C:/maven2
+		path = strings.TrimPrefix(path, `\`)
+	}
+
+	return os.Open(path)
+}
+
+// moveRepositoryCredentialsFromURL strips any userinfo from the repository URL, using it for basic auth
+// unless credentials were configured explicitly with BinaryRepositoryAuth.
+func moveRepositoryCredentialsFromURL(config Config) Config {
+	repositoryURL, err := url.Parse(config.binaryRepositoryURL)
+	if err != nil || repositoryURL.User == nil {
+		return config
+	}
+
+	if config.binaryRepositoryUsername == "" && config.binaryRepositoryPassword == "" {
+		config.binaryRepositoryUsername = repositoryURL.User.Username()
+		config.binaryRepositoryPassword, _ = repositoryURL.User.Password()
+	}
+
+	repositoryURL.User = nil
+	config.binaryRepositoryURL = repositoryURL.String()
+
+	return config
+}
+
+// httpGetWithRetries performs a GET request, retrying network errors and 5xx responses
+// up to config.fetchRetries times with exponential backoff.
+func httpGetWithRetries(ctx context.Context, config Config, downloadURL string) (*http.Response, error) {
+	backoff := config.fetchRetryBackoff
+
+	for attempt := 0; ; attempt++ {
+		response, err := httpGet(ctx, config, downloadURL)
+		if err == nil && response.StatusCode < http.StatusInternalServerError {
+			return response, nil
+		}
+
+		if attempt >= config.fetchRetries || ctx.Err() != nil {
+			return response, err
+		}
+
+		var reason string
+		if err != nil {
+			reason = err.Error()
+		} else {
+			reason = response.Status
+			closeBody(response)()
+		}
+
+		config.logf("failed to fetch %s (%s), retrying in %s (attempt %d of %d)",
+			downloadURL, reason, backoff, attempt+1, config.fetchRetries)
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
 	}
 }
 
@@ -75,6 +296,22 @@ func closeBody(resp *http.Response) func() {
 }
 
 func decompressResponse(bodyBytes []byte, contentLength int64, cacheLocator CacheLocator, downloadURL string) error {
+	file, err := findCompressedTar(bodyBytes, contentLength, downloadURL)
+	if err != nil {
+		return err
+	}
+
+	cacheLocation, _ := cacheLocator()
+
+	if err := os.MkdirAll(filepath.Dir(cacheLocation), 0755); err != nil {
+		return errorExtractingPostgres(err)
+	}
+
+	return decompressSingleFile(file, cacheLocation)
+}
+
+// findCompressedTar returns the binaries archive within the downloaded jar.
+func findCompressedTar(bodyBytes []byte, contentLength int64, downloadURL string) (*zip.File, error) {
 	size := contentLength
 	// if the content length is not set (i.e. chunked encoding),
 	// we need to use the length of the bodyBytes otherwise
@@ -84,27 +321,27 @@ func decompressResponse(bodyBytes []byte, contentLength int64, cacheLocator Cach
 	}
 	zipReader, err := zip.NewReader(bytes.NewReader(bodyBytes), size)
 	if err != nil {
-		return errorFetchingPostgres(err)
+		return nil, errorFetchingPostgres(err)
 	}
 
-	cacheLocation, _ := cacheLocator()
-
-	if err := os.MkdirAll(filepath.Dir(cacheLocation), 0755); err != nil {
-		return errorExtractingPostgres(err)
+	for _, file := range zipReader.File {
+		if !file.FileHeader.FileInfo().IsDir() && isCompressedTar(file.FileHeader.Name) {
+			return file, nil
+		}
 	}
 
-	for _, file := range zipReader.File {
-		if !file.FileHeader.FileInfo().IsDir() && strings.HasSuffix(file.FileHeader.Name, ".txz") {
-			if err := decompressSingleFile(file, cacheLocation); err != nil {
-				return err
-			}
+	return nil, fmt.Errorf("error fetching postgres: cannot find binary in archive retrieved from %s", downloadURL)
+}
 
-			// we have successfully found the file, return early
-			return nil
+// isCompressedTar reports whether name has the extension of one of the tar archive formats decompressTar supports.
+func isCompressedTar(name string) bool {
+	for _, extension := range []string{".txz", ".tar.xz", ".tgz", ".tar.gz", ".tzst", ".tar.zst"} {
+		if strings.HasSuffix(name, extension) {
+			return true
 		}
 	}
 
-	return fmt.Errorf("error fetching postgres: cannot find binary in archive retrieved from %s", downloadURL)
+	return false
 }
 
 func decompressSingleFile(file *zip.File, cacheLocation string) error {
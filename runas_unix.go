@@ -0,0 +1,84 @@
+//go:build !windows
+// +build !windows
+
+package embeddedpostgres
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"syscall"
+)
+
+// runAsCredential returns the credential of RunAsUser when running as root, or nil when processes run as the
+// current user.
+func runAsCredential(config Config) (*syscall.Credential, error) {
+	if config.runAsUser == "" || os.Geteuid() != 0 {
+		return nil, nil
+	}
+
+	runAs, err := user.Lookup(config.runAsUser)
+	if err != nil {
+		return nil, fmt.Errorf("unable to find RunAsUser %s: %w", config.runAsUser, err)
+	}
+
+	uid, err := strconv.ParseUint(runAs.Uid, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse uid %s of RunAsUser %s: %w", runAs.Uid, config.runAsUser, err)
+	}
+
+	gid, err := strconv.ParseUint(runAs.Gid, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse gid %s of RunAsUser %s: %w", runAs.Gid, config.runAsUser, err)
+	}
+
+	return &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid)}, nil
+}
+
+func checkRunAsUser(config Config) error {
+	_, err := runAsCredential(config)
+	return err
+}
+
+// chownToRunAsUser gives path, and everything within it, to RunAsUser when running as root.
+func chownToRunAsUser(config Config, path string) error {
+	credential, err := runAsCredential(config)
+	if err != nil || credential == nil {
+		return err
+	}
+
+	err = filepath.Walk(path, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		return os.Lchown(path, int(credential.Uid), int(credential.Gid))
+	})
+	if err != nil {
+		return fmt.Errorf("unable to give %s to RunAsUser %s: %w", path, config.runAsUser, err)
+	}
+
+	return nil
+}
+
+// matchDirectoryOwner gives path to the owner of the directory containing it when running as root, so that a file
+// written for a process running as RunAsUser can be read by it.
+func matchDirectoryOwner(path string) error {
+	if os.Geteuid() != 0 {
+		return nil
+	}
+
+	info, err := os.Stat(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+
+	return os.Chown(path, int(stat.Uid), int(stat.Gid))
+}
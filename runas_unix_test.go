@@ -0,0 +1,72 @@
+//go:build !windows
+// +build !windows
+
+package embeddedpostgres
+
+import (
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_runAsCredential_NotSet(t *testing.T) {
+	credential, err := runAsCredential(DefaultConfig())
+
+	assert.NoError(t, err)
+	assert.Nil(t, credential)
+}
+
+func Test_runAsCredential_NotRoot(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("requires running as a user other than root")
+	}
+
+	credential, err := runAsCredential(DefaultConfig().RunAsUser("no-such-user"))
+
+	assert.NoError(t, err)
+	assert.Nil(t, credential)
+}
+
+func Test_runAsCredential_UnknownUser(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("requires running as root")
+	}
+
+	assert.EqualError(t, checkRunAsUser(DefaultConfig().RunAsUser("no-such-user")), "unable to find RunAsUser no-such-user: user: unknown user no-such-user")
+}
+
+func Test_runAsCredential_AppliedWhenRoot(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("requires running as root")
+	}
+
+	nobody, err := user.Lookup("nobody")
+	if err != nil {
+		t.Skip("requires a nobody user")
+	}
+
+	config := DefaultConfig().RunAsUser("nobody")
+
+	cmd := exec.Command("true")
+	applyPlatformSpecificOptions(cmd, config)
+
+	require.NotNil(t, cmd.SysProcAttr)
+	require.NotNil(t, cmd.SysProcAttr.Credential)
+	assert.Equal(t, nobody.Uid, strconv.FormatUint(uint64(cmd.SysProcAttr.Credential.Uid), 10))
+	assert.Equal(t, nobody.Gid, strconv.FormatUint(uint64(cmd.SysProcAttr.Credential.Gid), 10))
+
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "file"), nil, 0600))
+	require.NoError(t, chownToRunAsUser(config, dir))
+
+	info, err := os.Stat(filepath.Join(dir, "file"))
+	require.NoError(t, err)
+	assert.Equal(t, cmd.SysProcAttr.Credential.Uid, info.Sys().(*syscall.Stat_t).Uid)
+}
@@ -0,0 +1,22 @@
+//go:build windows
+// +build windows
+
+package embeddedpostgres
+
+import "errors"
+
+func checkRunAsUser(config Config) error {
+	if config.runAsUser != "" {
+		return errors.New("RunAsUser is not supported on Windows")
+	}
+
+	return nil
+}
+
+func chownToRunAsUser(config Config, path string) error {
+	return nil
+}
+
+func matchDirectoryOwner(path string) error {
+	return nil
+}
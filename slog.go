@@ -0,0 +1,23 @@
+//go:build go1.21
+
+package embeddedpostgres
+
+import (
+	"log/slog"
+)
+
+// SLogger sets a structured logger for postgres output.
+// Each line of output is logged at debug level with a "component" attribute of "postgres".
+// When set it takes precedence over Logger.
+func (c Config) SLogger(logger *slog.Logger) Config {
+	if logger == nil {
+		c.logLine = nil
+		return c
+	}
+
+	c.logLine = func(line string) {
+		logger.Debug(line, slog.String("component", "postgres"))
+	}
+
+	return c
+}
@@ -0,0 +1,36 @@
+//go:build go1.21
+
+package embeddedpostgres
+
+import (
+	"bytes"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_SLogger_ForwardsLinesAsDebugRecords(t *testing.T) {
+	var out bytes.Buffer
+	handler := slog.NewTextHandler(&out, &slog.HandlerOptions{
+		Level: slog.LevelDebug,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.TimeKey {
+				return slog.Attr{}
+			}
+			return a
+		},
+	})
+
+	config := DefaultConfig().SLogger(slog.New(handler))
+
+	sl, err := newSyncedLogger("", newLineWriter(config.logLine))
+	require.NoError(t, err)
+
+	_, err = sl.file.WriteString("first line\nsecond line\npartial")
+	require.NoError(t, err)
+	require.NoError(t, sl.flush())
+
+	assert.Equal(t, "level=DEBUG msg=\"first line\" component=postgres\nlevel=DEBUG msg=\"second line\" component=postgres\n", out.String())
+}
@@ -0,0 +1,107 @@
+package embeddedpostgres
+
+import (
+	"context"
+	"fmt"
+	"os"
+)
+
+// Snapshot stops Postgres, copies its data directory to path and starts it again, so that Reset can later return to
+// the state at this point. Any existing content of path is replaced.
+func (ep *EmbeddedPostgres) Snapshot(path string) error {
+	if !ep.started {
+		return ErrServerNotStarted
+	}
+
+	err := ep.whileStopped(func() error {
+		if err := os.RemoveAll(path); err != nil {
+			return fmt.Errorf("unable to clean up snapshot directory %s with error: %s", path, err)
+		}
+
+		if err := copyDataDirectory(ep.config.dataPath, path); err != nil {
+			return fmt.Errorf("unable to copy data directory %s to snapshot %s with error: %s", ep.config.dataPath, path, err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	ep.snapshotPath = path
+
+	return nil
+}
+
+// Reset stops Postgres, replaces its data directory with the most recent Snapshot and starts it again. This is far
+// cheaper than re-seeding a database between tests.
+func (ep *EmbeddedPostgres) Reset() error {
+	if !ep.started {
+		return ErrServerNotStarted
+	}
+
+	if ep.snapshotPath == "" {
+		return ErrNoSnapshot
+	}
+
+	return ep.whileStopped(func() error {
+		if err := os.RemoveAll(ep.config.dataPath); err != nil {
+			return fmt.Errorf("unable to clean up data directory %s with error: %s", ep.config.dataPath, err)
+		}
+
+		if err := copyDataDirectory(ep.snapshotPath, ep.config.dataPath); err != nil {
+			return fmt.Errorf("unable to copy snapshot %s to data directory %s with error: %s", ep.snapshotPath, ep.config.dataPath, err)
+		}
+
+		return chownToRunAsUser(ep.config, ep.config.dataPath)
+	})
+}
+
+// whileStopped runs fn with the Postgres process stopped, so the data directory is consistent, then starts it again.
+// An error from fn takes precedence over one from restarting.
+func (ep *EmbeddedPostgres) whileStopped(fn func() error) error {
+	// the caller's context may already be done, stopping must not be abandoned
+	ctx := context.Background()
+
+	if ep.processWatcher != nil {
+		ep.processWatcher.Stop()
+		ep.processWatcher = nil
+	}
+
+	if err := stopPostgres(ctx, ep); err != nil {
+		return err
+	}
+
+	ep.started = false
+
+	fnErr := fn()
+
+	if err := startPostgres(ctx, ep); err != nil {
+		if fnErr != nil {
+			return fnErr
+		}
+
+		return err
+	}
+
+	if err := ep.syncedLogger.flush(); err != nil {
+		return err
+	}
+
+	ep.started = true
+
+	if err := healthCheckDatabaseOrTimeout(ctx, ep.config); err != nil {
+		return err
+	}
+
+	if ep.config.onUnexpectedExit != nil {
+		pid, err := readPostmasterPID(ep.config.dataPath)
+		if err != nil {
+			return fmt.Errorf("unable to watch postgres process: %w", err)
+		}
+
+		ep.processWatcher = watchProcess(pid, ep.config.onUnexpectedExit)
+	}
+
+	return fnErr
+}
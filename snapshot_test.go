@@ -0,0 +1,66 @@
+package embeddedpostgres
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_SnapshotAndResetBeforeStart(t *testing.T) {
+	database := NewDatabase()
+
+	assert.ErrorIs(t, database.Snapshot(t.TempDir()), ErrServerNotStarted)
+	assert.ErrorIs(t, database.Reset(), ErrServerNotStarted)
+}
+
+func Test_ResetWithoutSnapshot(t *testing.T) {
+	database := NewDatabase()
+	database.started = true
+
+	assert.ErrorIs(t, database.Reset(), ErrNoSnapshot)
+}
+
+func Test_SnapshotAndReset(t *testing.T) {
+	database := NewDatabase(DefaultConfig().
+		InitSQL("CREATE TABLE things (id integer)"))
+
+	if err := database.Start(); err != nil {
+		shutdownDBAndFatal(t, err, database)
+	}
+
+	defer func() {
+		if err := database.Stop(); err != nil {
+			shutdownDBAndFatal(t, err, database)
+		}
+	}()
+
+	require.NoError(t, database.Snapshot(filepath.Join(t.TempDir(), "snapshot")))
+
+	countThings := func() int {
+		db, err := sql.Open("postgres", database.ConnectionString())
+		require.NoError(t, err)
+
+		defer db.Close()
+
+		var count int
+		require.NoError(t, db.QueryRow("SELECT count(*) FROM things").Scan(&count))
+
+		return count
+	}
+
+	db, err := sql.Open("postgres", database.ConnectionString())
+	require.NoError(t, err)
+
+	_, err = db.Exec("INSERT INTO things VALUES (1)")
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	assert.Equal(t, 1, countThings())
+
+	require.NoError(t, database.Reset())
+
+	assert.Equal(t, 0, countThings())
+}
@@ -0,0 +1,100 @@
+package embeddedpostgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// baseBackupCommand returns the pg_basebackup invocation copying the data directory of the StandbyMode primary into
+// DataPath, streaming the WAL written meanwhile so that the copy is consistent.
+func baseBackupCommand(ctx context.Context, config Config) *exec.Cmd {
+	return exec.CommandContext(ctx, filepath.Join(config.binariesPath, "bin", "pg_basebackup"), baseBackupArgs(config, config.standbyPrimaryConnInfo)...)
+}
+
+// redactedBaseBackupCommand returns the pg_basebackup command line with the primary connection string, which may hold
+// a password, left out.
+func redactedBaseBackupCommand(config Config) string {
+	return strings.Join(append([]string{filepath.Join(config.binariesPath, "bin", "pg_basebackup")}, baseBackupArgs(config, "<primary conninfo>")...), " ")
+}
+
+func baseBackupArgs(config Config, primaryConnInfo string) []string {
+	return []string{"-D", config.dataPath, "-d", primaryConnInfo, "-X", "stream", "--no-password"}
+}
+
+// cleanDataDirectoryAndBaseBackup replaces the data directory with a base backup of the StandbyMode primary.
+func (ep *EmbeddedPostgres) cleanDataDirectoryAndBaseBackup(ctx context.Context) error {
+	if err := ep.cleanDataDirectory(); err != nil {
+		return err
+	}
+
+	baseBackupProcess := baseBackupCommand(ctx, ep.config)
+	baseBackupProcess.Stdout = ep.syncedLogger.file
+	baseBackupProcess.Stderr = ep.syncedLogger.stderrFile()
+	ep.configureCommand(baseBackupProcess)
+
+	if err := baseBackupProcess.Run(); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		// the command line is left out of the error as the connection string may hold a password
+		logContent, _ := ep.syncedLogger.readLogs()
+
+		return fmt.Errorf("unable to create standby data directory %s using pg_basebackup: %w\n%s", ep.config.dataPath, err, string(logContent))
+	}
+
+	return nil
+}
+
+// writeStandbyFiles marks the data directory so that Postgres starts as a standby of the StandbyMode primary. From
+// Postgres 12 this is an empty standby.signal with primary_conninfo set in postgresql.auto.conf, as pg_basebackup -R
+// does, while older versions read both from recovery.conf. The connection string is written to the data directory
+// rather than passed on the command line, which ends up in logs and errors, as it may hold a password.
+func writeStandbyFiles(config Config) error {
+	primaryConnInfo := fmt.Sprintf("primary_conninfo = '%s'\n", strings.ReplaceAll(config.standbyPrimaryConnInfo, "'", "''"))
+
+	if config.usesRecoveryConf() {
+		return writeDataFile(config, "recovery.conf", "standby_mode = 'on'\n"+primaryConnInfo)
+	}
+
+	if err := writeDataFile(config, "standby.signal", ""); err != nil {
+		return err
+	}
+
+	autoConfPath := filepath.Join(config.dataPath, "postgresql.auto.conf")
+
+	autoConf, err := os.ReadFile(autoConfPath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("unable to read %s with error: %s", autoConfPath, err)
+	}
+
+	// replace the primary_conninfo of a previous Start, which may point at another primary
+	var lines []string
+	for _, line := range strings.SplitAfter(string(autoConf), "\n") {
+		if line != "" && !strings.HasPrefix(strings.TrimSpace(line), "primary_conninfo") {
+			lines = append(lines, line)
+		}
+	}
+
+	content := strings.Join(lines, "")
+	if content != "" && !strings.HasSuffix(content, "\n") {
+		content += "\n"
+	}
+
+	return writeDataFile(config, "postgresql.auto.conf", content+primaryConnInfo)
+}
+
+func writeDataFile(config Config, name, content string) error {
+	path := filepath.Join(config.dataPath, name)
+
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		return fmt.Errorf("unable to write %s with error: %s", path, err)
+	}
+
+	return nil
+}
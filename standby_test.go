@@ -0,0 +1,92 @@
+package embeddedpostgres
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_writeStandbyFiles(t *testing.T) {
+	dataPath := t.TempDir()
+
+	require.NoError(t, writeStandbyFiles(DefaultConfig().DataPath(dataPath).StandbyMode("host=localhost password='s3cret'")))
+
+	content, err := os.ReadFile(filepath.Join(dataPath, "standby.signal"))
+	require.NoError(t, err)
+	assert.Empty(t, content)
+	assert.NoFileExists(t, filepath.Join(dataPath, "recovery.conf"))
+
+	content, err = os.ReadFile(filepath.Join(dataPath, "postgresql.auto.conf"))
+	require.NoError(t, err)
+	assert.Equal(t, "primary_conninfo = 'host=localhost password=''s3cret'''\n", string(content))
+}
+
+func Test_writeStandbyFiles_ReplacesPrimaryConnInfo(t *testing.T) {
+	dataPath := t.TempDir()
+	autoConf := "# Do not edit this file manually!\nwork_mem = '8MB'\nprimary_conninfo = 'host=old'\n"
+	require.NoError(t, os.WriteFile(filepath.Join(dataPath, "postgresql.auto.conf"), []byte(autoConf), 0600))
+
+	require.NoError(t, writeStandbyFiles(DefaultConfig().DataPath(dataPath).StandbyMode("host=new")))
+
+	content, err := os.ReadFile(filepath.Join(dataPath, "postgresql.auto.conf"))
+	require.NoError(t, err)
+	assert.Equal(t, "# Do not edit this file manually!\nwork_mem = '8MB'\nprimary_conninfo = 'host=new'\n", string(content))
+}
+
+func Test_writeStandbyFiles_RecoveryConf(t *testing.T) {
+	dataPath := t.TempDir()
+
+	require.NoError(t, writeStandbyFiles(DefaultConfig().Version(V11).DataPath(dataPath).StandbyMode("host=localhost password='s3cret'")))
+
+	content, err := os.ReadFile(filepath.Join(dataPath, "recovery.conf"))
+	require.NoError(t, err)
+	assert.Equal(t, "standby_mode = 'on'\nprimary_conninfo = 'host=localhost password=''s3cret'''\n", string(content))
+	assert.NoFileExists(t, filepath.Join(dataPath, "standby.signal"))
+	assert.NoFileExists(t, filepath.Join(dataPath, "postgresql.auto.conf"))
+}
+
+func Test_redactedBaseBackupCommand(t *testing.T) {
+	config := DefaultConfig().BinariesPath("/binaries").DataPath("/data").StandbyMode("host=primary password=s3cret")
+
+	assert.Equal(t, filepath.Join("/binaries", "bin", "pg_basebackup")+" -D /data -d <primary conninfo> -X stream --no-password", redactedBaseBackupCommand(config))
+	assert.NotContains(t, redactedBaseBackupCommand(config), "s3cret")
+}
+
+func Test_StandbyMode(t *testing.T) {
+	primary := NewDatabase(DefaultConfig().
+		Port(9898).
+		RuntimePath(filepath.Join(t.TempDir(), "primary")))
+
+	if err := primary.Start(); err != nil {
+		shutdownDBAndFatal(t, err, primary)
+	}
+
+	defer func() {
+		if err := primary.Stop(); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	standby := NewDatabase(DefaultConfig().
+		Port(9899).
+		RuntimePath(filepath.Join(t.TempDir(), "standby")).
+		StandbyMode("host=localhost port=9898 user=postgres password=postgres"))
+
+	if err := standby.Start(); err != nil {
+		shutdownDBAndFatal(t, err, standby)
+	}
+
+	rows, err := standby.Query("postgres", "SELECT pg_is_in_recovery()")
+	if err != nil {
+		shutdownDBAndFatal(t, err, standby)
+	}
+
+	if err := standby.Stop(); err != nil {
+		shutdownDBAndFatal(t, err, standby)
+	}
+
+	assert.Equal(t, [][]string{{"t"}}, rows)
+}
@@ -0,0 +1,91 @@
+package embeddedpostgres
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ServerStatus describes whether a Postgres server is running on the configured data directory, as reported by Status.
+type ServerStatus struct {
+	// Running is true when a server is running on the data directory.
+	Running bool
+	// PID is the process ID of the postmaster when Running, otherwise 0.
+	PID int
+}
+
+// pgCtlStatusPIDPattern matches the PID in pg_ctl status output, e.g. "pg_ctl: server is running (PID: 42)".
+//
+//nolint:gochecknoglobals
+var pgCtlStatusPIDPattern = regexp.MustCompile(`\(PID: ([0-9]+)\)`)
+
+// Status runs pg_ctl status against the data directory to report whether a server is running on it, including one
+// started by another process, so that a server can be started only when it is not already up. Unlike most methods
+// Status does not require Start to have been called, but the binaries must already have been extracted.
+func (ep *EmbeddedPostgres) Status() (ServerStatus, error) {
+	runtimePath := ep.config.runtimePath
+	if runtimePath == "" {
+		cacheLocation, _ := ep.cacheLocator()
+		runtimePath = ep.config.defaultRuntimePath(cacheLocation)
+	}
+
+	binariesPath := ep.config.binariesPath
+	if binariesPath == "" {
+		binariesPath = runtimePath
+	}
+
+	dataPath := ep.config.dataPath
+	if dataPath == "" {
+		dataPath = filepath.Join(runtimePath, "data")
+	}
+
+	pgCtl := filepath.Join(binariesPath, "bin", "pg_ctl")
+	if _, err := exec.LookPath(pgCtl); err != nil {
+		return ServerStatus{}, fmt.Errorf("unable to check server status, pg_ctl not found in %s: %w", binariesPath, err)
+	}
+
+	var output bytes.Buffer
+
+	cmd := exec.Command(pgCtl, "status", "-D", dataPath)
+	cmd.Env = ep.config.processEnvironment()
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+
+	err := cmd.Run()
+
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		return parsePgCtlStatus(output.String())
+	case errors.As(err, &exitErr) && exitErr.ExitCode() == 3:
+		// pg_ctl status exits with 3 when no server is running
+		return ServerStatus{}, nil
+	case errors.As(err, &exitErr) && exitErr.ExitCode() == 4:
+		// and with 4 when the data directory is not accessible, which is expected before it is initialised
+		if _, statErr := os.Stat(dataPath); errors.Is(statErr, os.ErrNotExist) {
+			return ServerStatus{}, nil
+		}
+	}
+
+	return ServerStatus{}, fmt.Errorf("unable to check server status using '%s': %s\n%s", cmd.String(), err, strings.TrimSpace(output.String()))
+}
+
+func parsePgCtlStatus(output string) (ServerStatus, error) {
+	match := pgCtlStatusPIDPattern.FindStringSubmatch(output)
+	if match == nil {
+		return ServerStatus{}, fmt.Errorf("unexpected pg_ctl status output %q", output)
+	}
+
+	pid, err := strconv.Atoi(match[1])
+	if err != nil {
+		return ServerStatus{}, fmt.Errorf("unexpected pg_ctl status output %q", output)
+	}
+
+	return ServerStatus{Running: true, PID: pid}, nil
+}
@@ -0,0 +1,118 @@
+package embeddedpostgres
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeFakePgCtl(t *testing.T, script string) string {
+	binariesPath := t.TempDir()
+
+	require.NoError(t, os.MkdirAll(filepath.Join(binariesPath, "bin"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(binariesPath, "bin", "pg_ctl"), []byte("#!/bin/sh\n"+script), 0755))
+
+	return binariesPath
+}
+
+func Test_Status_Running(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake binaries are shell scripts")
+	}
+
+	binariesPath := writeFakePgCtl(t, "echo 'pg_ctl: server is running (PID: 4242)'\necho \"/usr/bin/postgres -D $3\"\n")
+
+	status, err := NewDatabase(DefaultConfig().BinariesPath(binariesPath).DataPath(t.TempDir())).Status()
+
+	require.NoError(t, err)
+	assert.Equal(t, ServerStatus{Running: true, PID: 4242}, status)
+}
+
+func Test_Status_Stopped(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake binaries are shell scripts")
+	}
+
+	binariesPath := writeFakePgCtl(t, "echo 'pg_ctl: no server running'\nexit 3\n")
+
+	status, err := NewDatabase(DefaultConfig().BinariesPath(binariesPath).DataPath(t.TempDir())).Status()
+
+	require.NoError(t, err)
+	assert.Equal(t, ServerStatus{}, status)
+}
+
+func Test_Status_StoppedWhenDataPathMissing(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake binaries are shell scripts")
+	}
+
+	binariesPath := writeFakePgCtl(t, "echo \"pg_ctl: directory \\\"$3\\\" does not exist\"\nexit 4\n")
+
+	status, err := NewDatabase(DefaultConfig().BinariesPath(binariesPath).DataPath(filepath.Join(t.TempDir(), "data"))).Status()
+
+	require.NoError(t, err)
+	assert.Equal(t, ServerStatus{}, status)
+}
+
+func Test_Status_ErrorWhenDataPathInaccessible(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake binaries are shell scripts")
+	}
+
+	binariesPath := writeFakePgCtl(t, "echo 'pg_ctl: could not open PID file: Permission denied'\nexit 4\n")
+
+	_, err := NewDatabase(DefaultConfig().BinariesPath(binariesPath).DataPath(t.TempDir())).Status()
+
+	assert.ErrorContains(t, err, "unable to check server status using")
+	assert.ErrorContains(t, err, "could not open PID file: Permission denied")
+}
+
+func Test_Status_ErrorWhenBinariesMissing(t *testing.T) {
+	binariesPath := t.TempDir()
+
+	_, err := NewDatabase(DefaultConfig().BinariesPath(binariesPath)).Status()
+
+	assert.ErrorContains(t, err, "unable to check server status, pg_ctl not found in "+binariesPath)
+}
+
+func Test_Status_AcrossStartAndStop(t *testing.T) {
+	database := NewDatabase(DefaultConfig().Port(9895))
+
+	if err := database.Start(); err != nil {
+		shutdownDBAndFatal(t, err, database)
+	}
+
+	status, err := database.Status()
+	if err != nil {
+		shutdownDBAndFatal(t, err, database)
+	}
+
+	pid, err := database.PID()
+	if err != nil {
+		shutdownDBAndFatal(t, err, database)
+	}
+
+	if err := database.Stop(); err != nil {
+		shutdownDBAndFatal(t, err, database)
+	}
+
+	assert.Equal(t, ServerStatus{Running: true, PID: pid}, status)
+
+	status, err = database.Status()
+	require.NoError(t, err)
+	assert.Equal(t, ServerStatus{}, status)
+}
+
+func Test_parsePgCtlStatus(t *testing.T) {
+	status, err := parsePgCtlStatus("pg_ctl: server is running (PID: 42)\n/opt/bin/postgres \"-D\" \"/data\"\n")
+
+	require.NoError(t, err)
+	assert.Equal(t, ServerStatus{Running: true, PID: 42}, status)
+
+	_, err = parsePgCtlStatus("pg_ctl: server is running\n")
+	assert.EqualError(t, err, `unexpected pg_ctl status output "pg_ctl: server is running\n"`)
+}
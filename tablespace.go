@@ -0,0 +1,144 @@
+package embeddedpostgres
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// tablespaceNames returns the names of the configured Tablespaces in a stable order.
+func (c Config) tablespaceNames() []string {
+	names := make([]string, 0, len(c.tablespaces))
+	for name := range c.tablespaces {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// createTablespaces creates the configured Tablespaces as the superuser, preparing each location first. When a
+// separate SuperuserName is configured they are owned by Username, like the databases.
+func createTablespaces(config Config) (err error) {
+	conn, err := openDatabaseConnection(config.connectionHost(), config.port, config.superuser(), config.password, config.maintenanceDatabase)
+	if err != nil {
+		return err
+	}
+
+	db := sql.OpenDB(conn)
+	defer func() {
+		err = connectionClose(db, err)
+	}()
+
+	for _, name := range config.tablespaceNames() {
+		location := absolutePath(config.tablespaces[name])
+
+		if err := prepareTablespaceLocation(config, location); err != nil {
+			return err
+		}
+
+		if _, err := db.Exec(createTablespaceStatement(config, name, location)); err != nil {
+			return fmt.Errorf("unable to create tablespace %s: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+// createTablespaceStatement returns the statement creating the tablespace name at location.
+func createTablespaceStatement(config Config, name, location string) string {
+	statement := "CREATE TABLESPACE " + pq.QuoteIdentifier(name)
+	if config.superuser() != config.username {
+		statement += " OWNER " + pq.QuoteIdentifier(config.username)
+	}
+
+	return statement + " LOCATION " + pq.QuoteLiteral(location)
+}
+
+// prepareTablespaceLocation creates the directory of a tablespace, which Postgres requires to exist, be empty and be
+// owned by the user it runs as.
+func prepareTablespaceLocation(config Config, location string) error {
+	if err := os.MkdirAll(location, 0700); err != nil {
+		return fmt.Errorf("unable to create tablespace location %s with error: %s", location, err)
+	}
+
+	entries, err := os.ReadDir(location)
+	if err != nil {
+		return fmt.Errorf("unable to read tablespace location %s with error: %s", location, err)
+	}
+
+	if len(entries) > 0 {
+		return fmt.Errorf("unable to use tablespace location %s, it is not empty and may be in use by another tablespace", location)
+	}
+
+	probe, err := os.CreateTemp(location, "write_test")
+	if err != nil {
+		return fmt.Errorf("unable to use tablespace location %s, it is not writable: %w", location, err)
+	}
+
+	_ = probe.Close()
+
+	if err := os.Remove(probe.Name()); err != nil {
+		return fmt.Errorf("unable to use tablespace location %s with error: %s", location, err)
+	}
+
+	if err := os.Chmod(location, 0700); err != nil {
+		return fmt.Errorf("unable to change permissions of tablespace location %s with error: %s", location, err)
+	}
+
+	return chownToRunAsUser(config, location)
+}
+
+// removeTablespaceDirectories removes the directories the data directory at dataPath holds in the locations of the
+// configured Tablespaces, e.g. PG_16_202307071, so that the locations can be used again by the cluster which replaces
+// it. Other locations, and directories of other versions which belong to other clusters, are left in place. A clone of
+// TemplateDataPath shares the tablespaces of the template, so nothing is removed for it.
+func removeTablespaceDirectories(config Config, dataPath string) error {
+	if len(config.tablespaces) == 0 || config.templateDataPath != "" {
+		return nil
+	}
+
+	locations := make(map[string]bool, len(config.tablespaces))
+	for _, location := range config.tablespaces {
+		locations[absolutePath(location)] = true
+	}
+
+	content, err := os.ReadFile(filepath.Join(dataPath, "PG_VERSION"))
+	if err != nil {
+		return nil
+	}
+
+	major := strings.TrimSpace(string(content))
+
+	links, err := os.ReadDir(filepath.Join(dataPath, "pg_tblspc"))
+	if err != nil {
+		return nil
+	}
+
+	for _, link := range links {
+		location, err := os.Readlink(filepath.Join(dataPath, "pg_tblspc", link.Name()))
+		if err != nil || !locations[absolutePath(location)] {
+			continue
+		}
+
+		versionDirs, err := filepath.Glob(filepath.Join(location, "PG_"+major+"_*"))
+		if err != nil {
+			return err
+		}
+
+		for _, versionDir := range versionDirs {
+			if err := os.RemoveAll(versionDir); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return fmt.Errorf("unable to clean up tablespace directory %s with error: %s", versionDir, err)
+			}
+		}
+	}
+
+	return nil
+}
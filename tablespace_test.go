@@ -0,0 +1,144 @@
+package embeddedpostgres
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_createTablespaceStatement(t *testing.T) {
+	assert.Equal(t, `CREATE TABLESPACE "fast" LOCATION '/mnt/fast'`, createTablespaceStatement(DefaultConfig(), "fast", "/mnt/fast"))
+	assert.Equal(t, `CREATE TABLESPACE "fast" OWNER "gin" LOCATION '/mnt/it''s fast'`,
+		createTablespaceStatement(DefaultConfig().SuperuserName("postgres").Username("gin"), "fast", "/mnt/it's fast"))
+}
+
+func Test_tablespaceNames(t *testing.T) {
+	assert.Empty(t, DefaultConfig().tablespaceNames())
+	assert.Equal(t, []string{"archive", "fast", "slow"},
+		DefaultConfig().Tablespaces(map[string]string{"slow": "/mnt/slow", "archive": "/mnt/archive", "fast": "/mnt/fast"}).tablespaceNames())
+}
+
+func Test_prepareTablespaceLocation(t *testing.T) {
+	location := filepath.Join(t.TempDir(), "tablespaces", "fast")
+
+	require.NoError(t, prepareTablespaceLocation(DefaultConfig(), location))
+
+	info, err := os.Stat(location)
+	require.NoError(t, err)
+	assert.True(t, info.IsDir())
+
+	if runtime.GOOS != "windows" {
+		assert.Equal(t, os.FileMode(0700), info.Mode().Perm())
+	}
+
+	entries, err := os.ReadDir(location)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func Test_prepareTablespaceLocation_ErrorWhenNotEmpty(t *testing.T) {
+	location := t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(location, "PG_16_202307071"), 0700))
+
+	err := prepareTablespaceLocation(DefaultConfig(), location)
+
+	assert.EqualError(t, err, fmt.Sprintf("unable to use tablespace location %s, it is not empty and may be in use by another tablespace", location))
+}
+
+func Test_prepareTablespaceLocation_ErrorWhenNotWritable(t *testing.T) {
+	if runtime.GOOS == "windows" || os.Geteuid() == 0 {
+		t.Skip("directory permissions do not apply")
+	}
+
+	location := t.TempDir()
+	require.NoError(t, os.Chmod(location, 0500))
+
+	defer func() {
+		_ = os.Chmod(location, 0700)
+	}()
+
+	err := prepareTablespaceLocation(DefaultConfig(), location)
+
+	assert.ErrorContains(t, err, fmt.Sprintf("unable to use tablespace location %s, it is not writable", location))
+}
+
+func Test_removeTablespaceDirectories(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("pg_tblspc holds junctions on Windows")
+	}
+
+	dataPath := t.TempDir()
+	configured := t.TempDir()
+	other := t.TempDir()
+
+	require.NoError(t, os.WriteFile(filepath.Join(dataPath, "PG_VERSION"), []byte("16\n"), 0600))
+	require.NoError(t, os.Mkdir(filepath.Join(dataPath, "pg_tblspc"), 0700))
+	require.NoError(t, os.Symlink(configured, filepath.Join(dataPath, "pg_tblspc", "16384")))
+	require.NoError(t, os.Symlink(other, filepath.Join(dataPath, "pg_tblspc", "16385")))
+
+	for _, dir := range []string{filepath.Join(configured, "PG_16_202307071"), filepath.Join(configured, "PG_15_202209061"), filepath.Join(other, "PG_16_202307071")} {
+		require.NoError(t, os.Mkdir(dir, 0700))
+	}
+
+	config := DefaultConfig().Tablespaces(map[string]string{"fast": configured})
+
+	require.NoError(t, removeTablespaceDirectories(config.TemplateDataPath(t.TempDir()), dataPath))
+	assert.DirExists(t, filepath.Join(configured, "PG_16_202307071"))
+
+	require.NoError(t, removeTablespaceDirectories(config, dataPath))
+	assert.NoDirExists(t, filepath.Join(configured, "PG_16_202307071"))
+	assert.DirExists(t, filepath.Join(configured, "PG_15_202209061"))
+	assert.DirExists(t, filepath.Join(other, "PG_16_202307071"))
+}
+
+func Test_Tablespaces(t *testing.T) {
+	location := t.TempDir()
+	config := DefaultConfig().
+		Port(9890).
+		Tablespaces(map[string]string{"fast": filepath.Join(location, "fast")})
+
+	// the second Start initialises the erased data directory again, reusing the tablespace location
+	for i := 0; i < 2; i++ {
+		database := NewDatabase(config)
+
+		if err := database.Start(); err != nil {
+			shutdownDBAndFatal(t, err, database)
+		}
+
+		db, err := sql.Open("postgres", database.ConnectionString())
+		if err != nil {
+			shutdownDBAndFatal(t, err, database)
+		}
+
+		if _, err := db.Exec("CREATE TABLE beers (name text) TABLESPACE fast"); err != nil {
+			shutdownDBAndFatal(t, err, database)
+		}
+
+		if err := db.Close(); err != nil {
+			shutdownDBAndFatal(t, err, database)
+		}
+
+		if err := database.Stop(); err != nil {
+			shutdownDBAndFatal(t, err, database)
+		}
+	}
+}
+
+func Test_ErrorWhenTablespaceLocationInUse(t *testing.T) {
+	location := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(location, "beers"), nil, 0600))
+
+	database := NewDatabase(DefaultConfig().
+		Port(9890).
+		Tablespaces(map[string]string{"fast": location}))
+
+	err := database.Start()
+
+	assert.ErrorContains(t, err, fmt.Sprintf("unable to use tablespace location %s, it is not empty", location))
+}
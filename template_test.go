@@ -0,0 +1,40 @@
+package embeddedpostgres
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_copyDataDirectory(t *testing.T) {
+	src := t.TempDir()
+	dst := filepath.Join(t.TempDir(), "data")
+
+	require.NoError(t, os.Chmod(src, 0700))
+	require.NoError(t, os.MkdirAll(filepath.Join(src, "base", "1"), 0700))
+	require.NoError(t, os.WriteFile(filepath.Join(src, "PG_VERSION"), []byte("16\n"), 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(src, "base", "1", "1234"), []byte("relation"), 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(src, "postmaster.pid"), []byte("42\n"), 0600))
+
+	require.NoError(t, copyDataDirectory(src, dst))
+
+	version, err := os.ReadFile(filepath.Join(dst, "PG_VERSION"))
+	require.NoError(t, err)
+	assert.Equal(t, "16\n", string(version))
+
+	relation, err := os.ReadFile(filepath.Join(dst, "base", "1", "1234"))
+	require.NoError(t, err)
+	assert.Equal(t, "relation", string(relation))
+
+	assert.NoFileExists(t, filepath.Join(dst, "postmaster.pid"))
+
+	if runtime.GOOS != "windows" {
+		info, err := os.Stat(dst)
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0700), info.Mode().Perm())
+	}
+}
@@ -12,6 +12,14 @@ func createTempXzArchive() (string, func()) {
 	return writeFileWithBase64Content("remote_fetch_test*.txz", "/Td6WFoAAATm1rRGAgAhARYAAAB0L+Wj4Av/AKZdADIaSqdFdWDG5Dyin7tszujmfm9YJn6/1REVUfqW8HwXvgwbrrcDDc4Q2ql+L+ybLTxJ+QNhhaKnawviRjKhUOT3syXi2Ye8k4QMkeurnnCu4a8eoCV+hqNFWkk8/w8MzyMzQZ2D3wtvoaZV/KqJ8jyLbNVj+vsKrzqg5vbSGz5/h7F37nqN1V8ZsdCnKnDMZPzovM8RwtelDd0g3fPC0dG/W9PH4wAAAAC2dqs1k9ZA0QABwgGAGAAAIQZ5XbHEZ/sCAAAAAARZWg==")
 }
 
+func createTempGzipArchive() (string, func()) {
+	return writeFileWithBase64Content("remote_fetch_test*.tgz", "H4sIAAAAAAACA+3RwQ7CIAyA4T4Kb7B2tPg4RgWWHdwSYO8vC/Fisnhxi8Z+B3rpgeb3Y6IO9oXVSaRN1yb23GYDxGRRmFkQkHoRB0bgAEsul1S/EkMalpw39+pajG+OXA95zh/h1/716btv6m+ZtP/B/fN8D+fbPJUwlc/3d8zb/Ylf+ju0BAa1/+6u1iYzZjMgelBKKfU3Hh5vdewADAAA")
+}
+
+func createTempZstdArchive() (string, func()) {
+	return writeFileWithBase64Content("remote_fetch_test*.tar.zst", "KLUv/WQAC+0DAGIGExCgb9BLO/mFqmtt7V1u/Qamq5gbmA7IMRCD7cHE251QuCiMyawXZ/sIgpexQ+fOWZtcjHnlobPugyX/onn53f15tPe6YPJfeENLpwIVIKAZ6wbw/T9Y1XTYHg9NblNHglGIhRjgAhKFAodrF+AKpsZgzIx7mMDdaRQBs7rmPQ==")
+}
+
 func createTempZipArchive() (string, func()) {
 	return writeFileWithBase64Content("remote_fetch_test*.zip", "UEsDBBQACAAIAExBSlMAAAAAAAAAAAAAAAAaAAkAcmVtb3RlX2ZldGNoX3Rlc3Q4MDA0NjE5MDVVVAUAAfCfYmEBAAD//1BLBwgAAAAABQAAAAAAAABQSwMEFAAIAAAATEFKUwAAAAAAAAAAAAAAABUACQByZW1vdGVfZmV0Y2hfdGVzdC50eHpVVAUAAfCfYmH9N3pYWgAABObWtEYCACEBFgAAAHQv5aPgBf8Abl0AORlJ/tq+A8rMBye1kCuXLnw2aeeO0gdfXeVHCWpF8/VeZU/MTVkdLzI+XgKLEMlHJukIdxP7iSAuKts+v7aDrJu68RHNgIsXGrGouAjf780FXjTUjX4vXDh08vNY1yOBayt9z9dKHdoG9AeAIgAAAAAOKMpgA1Mm3wABigGADAAAjIVdpbHEZ/sCAAAAAARZWlBLBwhkmQgRsAAAALAAAABQSwECFAMUAAgACABMQUpTAAAAAAUAAAAAAAAAGgAJAAAAAAAAAAAAgIEAAAAAcmVtb3RlX2ZldGNoX3Rlc3Q4MDA0NjE5MDVVVAUAAfCfYmFQSwECFAMUAAgAAABMQUpTZJkIEbAAAACwAAAAFQAJAAAAAAAAAAAApIFWAAAAcmVtb3RlX2ZldGNoX3Rlc3QudHh6VVQFAAHwn2JhUEsFBgAAAAACAAIAnQAAAFIBAAAAAA==")
 }
@@ -48,6 +56,15 @@ func shutdownDBAndFail(t *testing.T, err error, db *EmbeddedPostgres) {
 	t.Errorf("Failed for version %s with error %s", db.config.version, err)
 }
 
+// shutdownDBAndFatal behaves as shutdownDBAndFail but ends the test immediately, so that it does not carry on with
+// results which are missing because of the failure.
+func shutdownDBAndFatal(t *testing.T, err error, db *EmbeddedPostgres) {
+	t.Helper()
+
+	shutdownDBAndFail(t, err, db)
+	t.FailNow()
+}
+
 func testVersionStrategy() VersionStrategy {
 	return func() (string, string, PostgresVersion) {
 		return "darwin", "amd64", "1.2.3"
@@ -0,0 +1,149 @@
+package embeddedpostgres
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	tlsCertFileName = "server.crt"
+	tlsKeyFileName  = "server.key"
+	tlsCAFileName   = "root.crt"
+)
+
+// tlsEnabled reports whether Postgres is configured to accept SSL connections.
+func (c Config) tlsEnabled() bool {
+	return c.tlsCertFile != "" || c.autoTLS
+}
+
+// checkTLSFiles ensures the configured certificate, key and CA files exist before anything is started.
+func checkTLSFiles(config Config) error {
+	for _, file := range []string{config.tlsCertFile, config.tlsKeyFile, config.tlsCAFile} {
+		if file == "" {
+			continue
+		}
+
+		if _, err := os.Stat(file); err != nil {
+			return fmt.Errorf("unable to use TLS file %s: %w", file, err)
+		}
+	}
+
+	return nil
+}
+
+// installTLSFiles writes the certificate, key and CA into the data directory where Postgres expects them, returning
+// the certificate. With AutoTLS a new self-signed certificate is generated, otherwise the configured files are copied.
+func installTLSFiles(config Config) ([]byte, error) {
+	files := map[string][]byte{}
+
+	if config.autoTLS {
+		certificate, key, err := generateSelfSignedCertificate(config.connectionHost())
+		if err != nil {
+			return nil, fmt.Errorf("unable to generate TLS certificate: %w", err)
+		}
+
+		files[tlsCertFileName] = certificate
+		files[tlsKeyFileName] = key
+	} else {
+		sources := map[string]string{
+			tlsCertFileName: config.tlsCertFile,
+			tlsKeyFileName:  config.tlsKeyFile,
+		}
+
+		if config.tlsCAFile != "" {
+			sources[tlsCAFileName] = config.tlsCAFile
+		}
+
+		for name, source := range sources {
+			content, err := os.ReadFile(source)
+			if err != nil {
+				return nil, fmt.Errorf("unable to read TLS file %s: %w", source, err)
+			}
+
+			files[name] = content
+		}
+	}
+
+	for name, content := range files {
+		// Postgres refuses to use a private key which is readable by other users
+		if err := os.WriteFile(filepath.Join(config.dataPath, name), content, 0600); err != nil {
+			return nil, fmt.Errorf("unable to install TLS file %s: %w", name, err)
+		}
+	}
+
+	return files[tlsCertFileName], nil
+}
+
+// generateSelfSignedCertificate returns a PEM encoded certificate and private key valid for host. The certificate is
+// its own authority so clients can trust it directly.
+func generateSelfSignedCertificate(host string) ([]byte, []byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	template := x509.Certificate{
+		SerialNumber:          serialNumber,
+		Subject:               pkix.Name{CommonName: host, Organization: []string{"embedded-postgres"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(365 * 24 * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		template.IPAddresses = []net.IP{ip}
+	} else {
+		template.DNSNames = []string{host}
+	}
+
+	if host == "localhost" {
+		template.IPAddresses = []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback}
+	}
+
+	certificate, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	privateKey, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificate}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: privateKey}),
+		nil
+}
+
+// tlsParameters returns the server parameters enabling SSL with the files installed by installTLSFiles.
+func tlsParameters(config Config) map[string]string {
+	parameters := map[string]string{
+		"ssl":           "on",
+		"ssl_cert_file": tlsCertFileName,
+		"ssl_key_file":  tlsKeyFileName,
+	}
+
+	if config.tlsCAFile != "" {
+		parameters["ssl_ca_file"] = tlsCAFileName
+	}
+
+	return parameters
+}
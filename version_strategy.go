@@ -1,10 +1,16 @@
 package embeddedpostgres
 
 import (
+	"context"
+	"encoding/xml"
+	"errors"
 	"fmt"
+	"net/http"
 	"os"
 	"os/exec"
+	"strconv"
 	"strings"
+	"sync"
 )
 
 // VersionStrategy provides a strategy that can be used to determine which version of Postgres should be used based on
@@ -16,40 +22,240 @@ func defaultVersionStrategy(config Config, goos, arch string, linuxMachineName f
 		goos := goos
 		arch := arch
 
+		// 32bit x86 binaries are published as i386
+		if arch == "386" {
+			arch = "i386"
+		}
+
 		if goos == "linux" {
 			// the zonkyio/embedded-postgres-binaries project produces
 			// arm binaries with the following name schema:
 			// 32bit: arm32v6 / arm32v7
 			// 64bit (aarch64): arm64v8
+			// other architectures, e.g. ppc64le, use the same name as GOARCH
 			if arch == "arm64" {
 				arch += "v8"
 			} else if arch == "arm" {
-				machineName := linuxMachineName()
-				if strings.HasPrefix(machineName, "armv7") {
-					arch += "32v7"
-				} else if strings.HasPrefix(machineName, "armv6") {
+				// armv7 binaries also run on armv8 machines with a 32bit userland, so are used unless the
+				// machine is known to be armv6
+				if strings.HasPrefix(linuxMachineName(), "armv6") {
 					arch += "32v6"
+				} else {
+					arch += "32v7"
 				}
 			}
 
-			if shouldUseAlpineLinuxBuild() {
+			useAlpineLinuxBuild := config.useAlpineLinuxBuild
+			if useAlpineLinuxBuild == nil {
+				detected := shouldUseAlpineLinuxBuild()
+				useAlpineLinuxBuild = &detected
+			}
+
+			if *useAlpineLinuxBuild {
 				arch += "-alpine"
 			}
 		}
 
 		// postgres below version 14.2 is not available for macos on arm
 		if goos == "darwin" && arch == "arm64" {
-			var majorVer, minorVer int
-			if _, err := fmt.Sscanf(string(config.version), "%d.%d", &majorVer, &minorVer); err == nil &&
-				(majorVer < 14 || (majorVer == 14 && minorVer < 2)) {
+			if isBelowVersion(config.version, 14, 2) {
 				arch = "amd64"
 			} else {
 				arch += "v8"
 			}
 		}
 
-		return goos, arch, config.version
+		version := config.version
+		if isPartialVersion(version) && config.offline {
+			if resolved, ok := resolveCachedPatchVersion(config, goos, arch); ok {
+				version = resolved
+			}
+		} else if isPartialVersion(version) {
+			// the version is resolved over the network by Start and Prepare, which can report failures
+			if resolved, ok := resolvedVersions.Load(resolvedVersionKey(config, goos, arch)); ok {
+				version = resolved.(PostgresVersion)
+			}
+		}
+
+		return goos, arch, version
+	}
+}
+
+// errUnsupportedPlatform is returned when no binaries are published for the operating system and architecture.
+var errUnsupportedPlatform = errors.New("unsupported platform")
+
+// publishedPlatforms are the platforms, as named by the VersionStrategy, for which the
+// zonkyio/embedded-postgres-binaries project publishes binaries. On linux each is also published for alpine.
+//
+//nolint:gochecknoglobals
+var publishedPlatforms = map[string][]string{
+	"darwin":  {"amd64", "arm64v8"},
+	"linux":   {"amd64", "i386", "arm32v6", "arm32v7", "arm64v8", "ppc64le"},
+	"windows": {"amd64", "i386"},
+}
+
+// checkPublishedPlatform returns an error wrapping errUnsupportedPlatform when no binaries are published for the
+// given platform, rather than letting the repository report each artifact as missing.
+func checkPublishedPlatform(operatingSystem, architecture string) error {
+	for _, published := range publishedPlatforms[operatingSystem] {
+		if architecture == published || (operatingSystem == "linux" && architecture == published+"-alpine") {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%w %s-%s, Postgres binaries are not published for it", errUnsupportedPlatform, operatingSystem, architecture)
+}
+
+// isBelowVersion reports whether version is older than major.minor.
+// A major only version is assumed to resolve to the latest patch release of that major version.
+func isBelowVersion(version PostgresVersion, major, minor int) bool {
+	var majorVer, minorVer int
+	if _, err := fmt.Sscanf(string(version), "%d.%d", &majorVer, &minorVer); err == nil {
+		return majorVer < major || (majorVer == major && minorVer < minor)
+	}
+
+	if _, err := fmt.Sscanf(string(version), "%d", &majorVer); err == nil {
+		return majorVer < major
+	}
+
+	return false
+}
+
+// isPartialVersion reports whether version omits the patch number, such as "16" or "9.6".
+func isPartialVersion(version PostgresVersion) bool {
+	return version != "" && strings.Count(string(version), ".") < 2
+}
+
+//nolint:gochecknoglobals
+var resolvedVersions sync.Map
+
+// resolvedVersionKey identifies the resolution of the configured partial version for the given platform.
+func resolvedVersionKey(config Config, operatingSystem, architecture string) string {
+	return binaryMetadataURL(moveRepositoryCredentialsFromURL(config), operatingSystem, architecture) + "#" + string(config.version)
+}
+
+func binaryMetadataURL(config Config, operatingSystem, architecture string) string {
+	return fmt.Sprintf("%s/io/zonky/test/postgres/embedded-postgres-binaries-%s-%s/maven-metadata.xml",
+		config.binaryRepositoryURL,
+		operatingSystem,
+		architecture)
+}
+
+// resolveLatestPatchVersion queries the Maven metadata of the binaries artifact for the given platform,
+// returning the newest published version starting with the configured partial version. Successful lookups are
+// cached, failed ones are retried by the next call.
+func resolveLatestPatchVersion(ctx context.Context, config Config, operatingSystem, architecture string) (PostgresVersion, error) {
+	cacheKey := resolvedVersionKey(config, operatingSystem, architecture)
+
+	if resolved, ok := resolvedVersions.Load(cacheKey); ok {
+		return resolved.(PostgresVersion), nil
+	}
+
+	latest, err := fetchLatestPatchVersion(ctx, config, operatingSystem, architecture)
+	if err != nil {
+		return "", err
+	}
+
+	resolvedVersions.Store(cacheKey, latest)
+
+	return latest, nil
+}
+
+func fetchLatestPatchVersion(ctx context.Context, config Config, operatingSystem, architecture string) (PostgresVersion, error) {
+	if err := checkPublishedPlatform(operatingSystem, architecture); err != nil {
+		return "", err
+	}
+
+	config = moveRepositoryCredentialsFromURL(config)
+	metadataURL := binaryMetadataURL(config, operatingSystem, architecture)
+
+	response, err := httpGetWithRetries(ctx, config, metadataURL)
+	if err != nil {
+		return "", fmt.Errorf("unable to fetch %s: %w", metadataURL, err)
+	}
+
+	defer closeBody(response)()
+
+	if response.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unable to fetch %s: %s", metadataURL, response.Status)
+	}
+
+	var metadata struct {
+		Versions []string `xml:"versioning>versions>version"`
+	}
+
+	if err := xml.NewDecoder(response.Body).Decode(&metadata); err != nil {
+		return "", fmt.Errorf("unable to parse %s: %w", metadataURL, err)
+	}
+
+	var latest PostgresVersion
+
+	for _, candidate := range metadata.Versions {
+		if strings.HasPrefix(candidate, string(config.version)+".") && compareVersions(PostgresVersion(candidate), latest) > 0 {
+			latest = PostgresVersion(candidate)
+		}
+	}
+
+	if latest == "" {
+		return "", fmt.Errorf("%w matching %s in %s", errVersionNotFound, config.version, metadataURL)
+	}
+
+	return latest, nil
+}
+
+// resolveCachedPatchVersion returns the newest version starting with the configured partial version of which the
+// binaries archive for the given platform is already in the cache, so that Offline never fetches the Maven metadata.
+func resolveCachedPatchVersion(config Config, operatingSystem, architecture string) (PostgresVersion, bool) {
+	entries, err := os.ReadDir(resolveCacheDirectory(config.cachePath))
+	if err != nil {
+		return "", false
 	}
+
+	platformPrefix := fmt.Sprintf(cachedArchivePrefix+"%s-%s-", operatingSystem, architecture)
+	prefix := platformPrefix + string(config.version) + "."
+
+	var latest PostgresVersion
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, ".txz") {
+			continue
+		}
+
+		candidate := PostgresVersion(strings.TrimSuffix(strings.TrimPrefix(name, platformPrefix), ".txz"))
+		if compareVersions(candidate, latest) > 0 {
+			latest = candidate
+		}
+	}
+
+	return latest, latest != ""
+}
+
+// compareVersions numerically compares dotted versions, returning -1, 0 or 1. An empty version sorts first.
+func compareVersions(a, b PostgresVersion) int {
+	aParts := strings.Split(string(a), ".")
+	bParts := strings.Split(string(b), ".")
+
+	for i := 0; i < len(aParts) || i < len(bParts); i++ {
+		var aPart, bPart int
+		if i < len(aParts) {
+			aPart, _ = strconv.Atoi(aParts[i])
+		}
+
+		if i < len(bParts) {
+			bPart, _ = strconv.Atoi(bParts[i])
+		}
+
+		if aPart != bPart {
+			if aPart < bPart {
+				return -1
+			}
+
+			return 1
+		}
+	}
+
+	return 0
 }
 
 func linuxMachineName() string {
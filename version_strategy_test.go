@@ -1,32 +1,38 @@
 package embeddedpostgres
 
 import (
+	"context"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func Test_DefaultVersionStrategy_AllGolangDistributions(t *testing.T) {
 	allGolangDistributions := map[string][]string{
 		"aix/ppc64":       {"aix", "ppc64"},
-		"android/386":     {"android", "386"},
+		"android/386":     {"android", "i386"},
 		"android/amd64":   {"android", "amd64"},
 		"android/arm":     {"android", "arm"},
 		"android/arm64":   {"android", "arm64"},
 		"darwin/amd64":    {"darwin", "amd64"},
 		"darwin/arm64":    {"darwin", "amd64"},
 		"dragonfly/amd64": {"dragonfly", "amd64"},
-		"freebsd/386":     {"freebsd", "386"},
+		"freebsd/386":     {"freebsd", "i386"},
 		"freebsd/amd64":   {"freebsd", "amd64"},
 		"freebsd/arm":     {"freebsd", "arm"},
 		"freebsd/arm64":   {"freebsd", "arm64"},
 		"illumos/amd64":   {"illumos", "amd64"},
 		"js/wasm":         {"js", "wasm"},
-		"linux/386":       {"linux", "386"},
+		"linux/386":       {"linux", "i386"},
 		"linux/amd64":     {"linux", "amd64"},
-		"linux/arm":       {"linux", "arm"},
+		"linux/arm":       {"linux", "arm32v7"},
 		"linux/arm64":     {"linux", "arm64v8"},
 		"linux/mips":      {"linux", "mips"},
 		"linux/mips64":    {"linux", "mips64"},
@@ -36,19 +42,19 @@ func Test_DefaultVersionStrategy_AllGolangDistributions(t *testing.T) {
 		"linux/ppc64le":   {"linux", "ppc64le"},
 		"linux/riscv64":   {"linux", "riscv64"},
 		"linux/s390x":     {"linux", "s390x"},
-		"netbsd/386":      {"netbsd", "386"},
+		"netbsd/386":      {"netbsd", "i386"},
 		"netbsd/amd64":    {"netbsd", "amd64"},
 		"netbsd/arm":      {"netbsd", "arm"},
 		"netbsd/arm64":    {"netbsd", "arm64"},
-		"openbsd/386":     {"openbsd", "386"},
+		"openbsd/386":     {"openbsd", "i386"},
 		"openbsd/amd64":   {"openbsd", "amd64"},
 		"openbsd/arm":     {"openbsd", "arm"},
 		"openbsd/arm64":   {"openbsd", "arm64"},
-		"plan9/386":       {"plan9", "386"},
+		"plan9/386":       {"plan9", "i386"},
 		"plan9/amd64":     {"plan9", "amd64"},
 		"plan9/arm":       {"plan9", "arm"},
 		"solaris/amd64":   {"solaris", "amd64"},
-		"windows/386":     {"windows", "386"},
+		"windows/386":     {"windows", "i386"},
 		"windows/amd64":   {"windows", "amd64"},
 		"windows/arm":     {"windows", "arm"},
 	}
@@ -58,6 +64,7 @@ func Test_DefaultVersionStrategy_AllGolangDistributions(t *testing.T) {
 		PostgresVersion("14.1.0"): {},
 		PostgresVersion("14.2.0"): {"darwin/arm64": {"darwin", "arm64v8"}},
 		V15:                       {"darwin/arm64": {"darwin", "arm64v8"}},
+		V17:                       {"darwin/arm64": {"darwin", "arm64v8"}},
 	}
 	defaultConfig := DefaultConfig()
 
@@ -92,6 +99,73 @@ func Test_DefaultVersionStrategy_AllGolangDistributions(t *testing.T) {
 	}
 }
 
+func Test_DefaultVersionStrategy_ArtifactCoordinates(t *testing.T) {
+	for _, tt := range []struct {
+		goos     string
+		goarch   string
+		alpine   bool
+		artifact string
+	}{
+		{"darwin", "amd64", false, "embedded-postgres-binaries-darwin-amd64"},
+		{"darwin", "arm64", false, "embedded-postgres-binaries-darwin-arm64v8"},
+		{"windows", "amd64", false, "embedded-postgres-binaries-windows-amd64"},
+		{"windows", "386", false, "embedded-postgres-binaries-windows-i386"},
+		{"linux", "amd64", false, "embedded-postgres-binaries-linux-amd64"},
+		{"linux", "386", false, "embedded-postgres-binaries-linux-i386"},
+		{"linux", "arm", false, "embedded-postgres-binaries-linux-arm32v7"},
+		{"linux", "arm64", false, "embedded-postgres-binaries-linux-arm64v8"},
+		{"linux", "ppc64le", false, "embedded-postgres-binaries-linux-ppc64le"},
+		{"linux", "amd64", true, "embedded-postgres-binaries-linux-amd64-alpine"},
+		{"linux", "arm", true, "embedded-postgres-binaries-linux-arm32v7-alpine"},
+		{"linux", "s390x", false, ""},
+		{"linux", "riscv64", false, ""},
+		{"linux", "ppc64", false, ""},
+		{"windows", "arm64", false, ""},
+		{"freebsd", "amd64", false, ""},
+	} {
+		t.Run(fmt.Sprintf("%s/%s/alpine=%t", tt.goos, tt.goarch, tt.alpine), func(t *testing.T) {
+			operatingSystem, architecture, _ := defaultVersionStrategy(
+				DefaultConfig().UseAlpineLinuxBuild(tt.alpine),
+				tt.goos,
+				tt.goarch,
+				func() string {
+					return ""
+				},
+				func() bool {
+					return false
+				})()
+
+			err := checkPublishedPlatform(operatingSystem, architecture)
+
+			if tt.artifact == "" {
+				assert.ErrorIs(t, err, errUnsupportedPlatform)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.artifact, "embedded-postgres-binaries-"+operatingSystem+"-"+architecture)
+		})
+	}
+}
+
+func Test_defaultRemoteFetchStrategy_ErrorWhenPlatformUnsupported(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected request for %s", r.URL)
+	}))
+	defer server.Close()
+
+	remoteFetchStrategy := defaultRemoteFetchStrategy(DefaultConfig().BinaryRepositoryURL(server.URL),
+		func() (string, string, PostgresVersion) {
+			return "linux", "s390x", V16
+		},
+		testCacheLocator())
+
+	err := remoteFetchStrategy(context.Background())
+
+	assert.ErrorIs(t, err, errUnsupportedPlatform)
+	assert.EqualError(t, err, "unsupported platform linux-s390x, Postgres binaries are not published for it")
+}
+
 func Test_DefaultVersionStrategy_Linux_ARM32V6(t *testing.T) {
 	operatingSystem, architecture, postgresVersion := defaultVersionStrategy(
 		DefaultConfig(),
@@ -124,6 +198,40 @@ func Test_DefaultVersionStrategy_Linux_ARM32V7(t *testing.T) {
 	assert.Equal(t, V16, postgresVersion)
 }
 
+func Test_DefaultVersionStrategy_Linux_Architectures(t *testing.T) {
+	for _, tt := range []struct {
+		arch        string
+		machineName string
+		expected    string
+	}{
+		{"arm", "armv6l", "arm32v6"},
+		{"arm", "armv7l", "arm32v7"},
+		{"arm", "armv8l", "arm32v7"},
+		{"arm", "", "arm32v7"},
+		{"arm64", "aarch64", "arm64v8"},
+		{"amd64", "x86_64", "amd64"},
+		{"ppc64le", "ppc64le", "ppc64le"},
+		{"s390x", "s390x", "s390x"},
+	} {
+		t.Run(fmt.Sprintf("%s_%s", tt.arch, tt.machineName), func(t *testing.T) {
+			operatingSystem, architecture, _ := defaultVersionStrategy(
+				DefaultConfig(),
+				"linux",
+				tt.arch,
+				func() string {
+					return tt.machineName
+				},
+				func() bool {
+					return false
+				},
+			)()
+
+			assert.Equal(t, "linux", operatingSystem)
+			assert.Equal(t, tt.expected, architecture)
+		})
+	}
+}
+
 func Test_DefaultVersionStrategy_Linux_Alpine(t *testing.T) {
 	operatingSystem, architecture, postgresVersion := defaultVersionStrategy(
 		DefaultConfig(),
@@ -142,8 +250,198 @@ func Test_DefaultVersionStrategy_Linux_Alpine(t *testing.T) {
 	assert.Equal(t, V16, postgresVersion)
 }
 
+func Test_DefaultVersionStrategy_Linux_AlpineOverride(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		config   Config
+		detected bool
+		expected string
+	}{
+		{"auto detected", DefaultConfig(), true, "amd64-alpine"},
+		{"auto not detected", DefaultConfig(), false, "amd64"},
+		{"forced on", DefaultConfig().UseAlpineLinuxBuild(true), false, "amd64-alpine"},
+		{"forced off", DefaultConfig().UseAlpineLinuxBuild(false), true, "amd64"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			_, architecture, _ := defaultVersionStrategy(
+				tt.config,
+				"linux",
+				"amd64",
+				func() string {
+					return ""
+				},
+				func() bool {
+					return tt.detected
+				},
+			)()
+
+			assert.Equal(t, tt.expected, architecture)
+		})
+	}
+}
+
 func Test_DefaultVersionStrategy_shouldUseAlpineLinuxBuild(t *testing.T) {
 	assert.NotPanics(t, func() {
 		shouldUseAlpineLinuxBuild()
 	})
 }
+
+func Test_DefaultVersionStrategy_ResolvesMajorVersion(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+
+		assert.Equal(t, "/io/zonky/test/postgres/embedded-postgres-binaries-linux-amd64/maven-metadata.xml", r.URL.Path)
+
+		_, _ = w.Write([]byte(`<metadata>
+  <groupId>io.zonky.test.postgres</groupId>
+  <artifactId>embedded-postgres-binaries-linux-amd64</artifactId>
+  <versioning>
+    <latest>17.0.0</latest>
+    <versions>
+      <version>16.2.0</version>
+      <version>16.10.0</version>
+      <version>16.4.0</version>
+      <version>17.0.0</version>
+      <version>1.0.0</version>
+    </versions>
+  </versioning>
+</metadata>`))
+	}))
+	defer server.Close()
+
+	config := DefaultConfig().Version(Major(16)).BinaryRepositoryURL(server.URL)
+	versionStrategy := defaultVersionStrategy(
+		config,
+		"linux",
+		"amd64",
+		func() string {
+			return ""
+		},
+		func() bool {
+			return false
+		},
+	)
+
+	_, _, unresolvedVersion := versionStrategy()
+	assert.Equal(t, Major(16), unresolvedVersion)
+	assert.Equal(t, 0, requests, "the version strategy never looks the version up itself")
+
+	resolved, err := resolveLatestPatchVersion(context.Background(), config, "linux", "amd64")
+	require.NoError(t, err)
+
+	_, _, version := versionStrategy()
+	_, _, cachedVersion := versionStrategy()
+
+	assert.Equal(t, PostgresVersion("16.10.0"), resolved)
+	assert.Equal(t, PostgresVersion("16.10.0"), version)
+	assert.Equal(t, PostgresVersion("16.10.0"), cachedVersion)
+	assert.Equal(t, 1, requests)
+}
+
+func Test_resolveLatestPatchVersion_RetriesAfterFailure(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		_, _ = w.Write([]byte(`<metadata><versioning><versions><version>13.16.0</version></versions></versioning></metadata>`))
+	}))
+	defer server.Close()
+
+	config := DefaultConfig().Version(Major(13)).BinaryRepositoryURL(server.URL)
+
+	_, err := resolveLatestPatchVersion(context.Background(), config, "linux", "amd64")
+	assert.ErrorContains(t, err, "503 Service Unavailable")
+
+	version, err := resolveLatestPatchVersion(context.Background(), config, "linux", "amd64")
+	require.NoError(t, err)
+	assert.Equal(t, PostgresVersion("13.16.0"), version)
+
+	_, err = resolveLatestPatchVersion(context.Background(), config, "linux", "amd64")
+	require.NoError(t, err)
+	assert.Equal(t, 2, requests, "successful lookups are cached")
+}
+
+func Test_resolveLatestPatchVersion_ContextCancelled(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	config := DefaultConfig().Version(Major(15)).BinaryRepositoryURL(server.URL)
+
+	_, err := resolveLatestPatchVersion(ctx, config, "linux", "amd64")
+	assert.ErrorIs(t, err, context.Canceled)
+
+	_, ok := resolvedVersions.Load(resolvedVersionKey(config, "linux", "amd64"))
+	assert.False(t, ok, "failed lookups are not cached")
+	assert.Equal(t, 0, requests)
+}
+
+func Test_DefaultVersionStrategy_OfflineResolvesCachedVersion(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected request for %s while offline", r.URL)
+	}))
+	defer server.Close()
+
+	cachePath := t.TempDir()
+	for _, name := range []string{
+		"embedded-postgres-binaries-linux-amd64-16.4.0.txz",
+		"embedded-postgres-binaries-linux-amd64-16.10.0.txz",
+		"embedded-postgres-binaries-linux-amd64-17.0.0.txz",
+		"embedded-postgres-binaries-linux-amd64-alpine-16.11.0.txz",
+		"embedded-postgres-binaries-linux-amd64-16.12.0.txz.lock",
+	} {
+		require.NoError(t, os.WriteFile(filepath.Join(cachePath, name), nil, 0600))
+	}
+
+	for _, tc := range []struct {
+		version  PostgresVersion
+		expected PostgresVersion
+	}{
+		{Major(16), "16.10.0"},
+		{Major(15), Major(15)},
+	} {
+		versionStrategy := defaultVersionStrategy(
+			DefaultConfig().Version(tc.version).BinaryRepositoryURL(server.URL).CachePath(cachePath).Offline(true),
+			"linux",
+			"amd64",
+			func() string {
+				return ""
+			},
+			func() bool {
+				return false
+			},
+		)
+
+		_, _, version := versionStrategy()
+
+		assert.Equal(t, tc.expected, version)
+	}
+}
+
+func Test_resolveLatestPatchVersion_ErrorWhenNoMatchingVersion(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`<metadata><versioning><versions><version>16.4.0</version></versions></versioning></metadata>`))
+	}))
+	defer server.Close()
+
+	_, err := resolveLatestPatchVersion(context.Background(), DefaultConfig().Version(Major(1)).BinaryRepositoryURL(server.URL), "linux", "amd64")
+
+	assert.ErrorIs(t, err, errVersionNotFound)
+}
+
+func Test_compareVersions(t *testing.T) {
+	assert.Equal(t, 1, compareVersions("16.10.0", "16.4.0"))
+	assert.Equal(t, -1, compareVersions("9.6.24", "10.23.0"))
+	assert.Equal(t, 0, compareVersions("16.4.0", "16.4.0"))
+	assert.Equal(t, 1, compareVersions("1.0.0", ""))
+}
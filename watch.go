@@ -0,0 +1,70 @@
+package embeddedpostgres
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrUnexpectedExit is reported to OnUnexpectedExit when Postgres stops running without Stop having been called.
+var ErrUnexpectedExit = errors.New("postgres exited unexpectedly")
+
+// processPollInterval is how often the Postgres process is checked for when OnUnexpectedExit is configured.
+const processPollInterval = 500 * time.Millisecond
+
+// processWatcher polls for the Postgres server process, which pg_ctl runs detached from this process.
+type processWatcher struct {
+	stop     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+	// notifying is set, atomically, while onExit runs
+	notifying int32
+}
+
+// watchProcess starts polling for the process with the given ID, calling onExit if it stops running before the
+// watcher is stopped.
+func watchProcess(pid int, onExit func(error)) *processWatcher {
+	w := &processWatcher{
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+
+	go func() {
+		defer close(w.done)
+
+		ticker := time.NewTicker(processPollInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-w.stop:
+				return
+			case <-ticker.C:
+				if !processExists(pid) {
+					atomic.StoreInt32(&w.notifying, 1)
+					onExit(fmt.Errorf("%w: process %d is no longer running", ErrUnexpectedExit, pid))
+					return
+				}
+			}
+		}
+	}()
+
+	return w
+}
+
+// Stop stops polling, waiting for any call to onExit in progress to return. Once onExit has been called Stop returns
+// immediately instead, as it may be called from onExit itself, e.g. by a callback calling EmbeddedPostgres.Stop,
+// which would otherwise wait for itself to return.
+func (w *processWatcher) Stop() {
+	w.stopOnce.Do(func() {
+		close(w.stop)
+	})
+
+	if atomic.LoadInt32(&w.notifying) == 1 {
+		return
+	}
+
+	<-w.done
+}
@@ -0,0 +1,113 @@
+package embeddedpostgres
+
+import (
+	"os"
+	"os/exec"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_watchProcess_ReportsExit(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sleep")
+	}
+
+	cmd := exec.Command("sleep", "30")
+	require.NoError(t, cmd.Start())
+
+	exited := make(chan error, 1)
+	watcher := watchProcess(cmd.Process.Pid, func(err error) {
+		exited <- err
+	})
+	defer watcher.Stop()
+
+	require.NoError(t, cmd.Process.Kill())
+	_ = cmd.Wait()
+
+	select {
+	case err := <-exited:
+		assert.ErrorIs(t, err, ErrUnexpectedExit)
+	case <-time.After(5 * time.Second):
+		t.Fatal("exit was not reported")
+	}
+}
+
+func Test_watchProcess_NotReportedAfterStop(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sleep")
+	}
+
+	cmd := exec.Command("sleep", "30")
+	require.NoError(t, cmd.Start())
+
+	watcher := watchProcess(cmd.Process.Pid, func(err error) {
+		t.Errorf("exit reported after the watcher was stopped: %s", err)
+	})
+	watcher.Stop()
+
+	require.NoError(t, cmd.Process.Kill())
+	_ = cmd.Wait()
+
+	time.Sleep(2 * processPollInterval)
+}
+
+func Test_watchProcess_StopFromOnExit(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sleep")
+	}
+
+	cmd := exec.Command("sleep", "30")
+	require.NoError(t, cmd.Start())
+
+	watchers := make(chan *processWatcher, 1)
+	stopped := make(chan struct{})
+	watcher := watchProcess(cmd.Process.Pid, func(err error) {
+		(<-watchers).Stop()
+		close(stopped)
+	})
+	watchers <- watcher
+
+	require.NoError(t, cmd.Process.Kill())
+	_ = cmd.Wait()
+
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("stopping the watcher from onExit did not return")
+	}
+
+	watcher.Stop()
+}
+
+func Test_OnUnexpectedExit(t *testing.T) {
+	exited := make(chan error, 1)
+
+	database := NewDatabase(DefaultConfig().
+		OnUnexpectedExit(func(err error) {
+			exited <- err
+		}))
+
+	if err := database.Start(); err != nil {
+		shutdownDBAndFatal(t, err, database)
+	}
+
+	pid, err := database.PID()
+	if err != nil {
+		shutdownDBAndFatal(t, err, database)
+	}
+
+	process, err := os.FindProcess(pid)
+	require.NoError(t, err)
+	require.NoError(t, process.Kill())
+
+	select {
+	case err := <-exited:
+		assert.ErrorIs(t, err, ErrUnexpectedExit)
+	case <-time.After(10 * time.Second):
+		t.Fatal("exit was not reported")
+	}
+}